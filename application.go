@@ -0,0 +1,130 @@
+// Package main - Application versions made of component versions, from the [Application] section of component.toml
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	resty "github.com/go-resty/resty/v2"
+	model "github.com/ortelius/scec-commons/model"
+	toml "github.com/pelletier/go-toml"
+)
+
+// componentApplication is the [Application] section of component.toml
+type componentApplication struct {
+	Name    string `toml:"Name"`    // Dotted domain and application name, ie GLOBAL.Store.Checkout
+	Version string `toml:"Version"` // Application version the component version is attached to
+}
+
+// readApplication returns the [Application] section with the ${var} references resolved.  The APPLICATION and APPLICATION_VERSION keys at the root are used when there is no section.
+func readApplication(filename string, tomlVars map[string]string) (*componentApplication, error) {
+	app := &componentApplication{
+		Name:    getWithDefault(tomlVars, "APPLICATION", ""),
+		Version: getWithDefault(tomlVars, "APPLICATION_VERSION", ""),
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		var doc struct {
+			Application componentApplication `toml:"Application"`
+		}
+
+		var vars map[interface{}]interface{}
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("could not parse the application in %s: %v", filename, err)
+		}
+		toml.Unmarshal(data, &vars)
+
+		if len(doc.Application.Name) > 0 {
+			app.Name = resolveVars(doc.Application.Name, vars)
+		}
+		if len(doc.Application.Version) > 0 {
+			app.Version = resolveVars(doc.Application.Version, vars)
+		}
+	}
+
+	if len(app.Name) == 0 {
+		return nil, nil
+	}
+
+	if len(app.Version) == 0 {
+		return nil, fmt.Errorf("application %s has no version", app.Name)
+	}
+	return app, nil
+}
+
+// attachToApplication creates or updates the application version and replaces the previous version of the component with the new component version
+func attachToApplication(client *resty.Client, app *componentApplication, component *model.ComponentVersion, user *model.User, created time.Time) error {
+	appName, appDomain := makeName(app.Name)
+	appName += ";" + app.Version
+
+	fullName := appName
+	if len(appDomain.Name) > 0 {
+		fullName = appDomain.Name + "." + appName
+	}
+
+	appver := model.NewApplicationVersionDetails()
+	resp, err := client.R().
+		SetResult(appver).
+		Get("http://localhost:8080/msapi/appver/" + url.PathEscape(fullName))
+
+	// Only a missing application version is created, any other failure could drop the components it already has
+	switch {
+	case err != nil:
+		return fmt.Errorf("could not read the application version %s: %v", fullName, err)
+	case resp.StatusCode() == http.StatusNotFound:
+		slog.Info("creating the application version", "appver", fullName)
+
+		appver = model.NewApplicationVersionDetails()
+		appver.Name = appName
+		appver.Domain = appDomain
+		appver.Created = created
+		appver.Creator = user
+		appver.Owner = user
+	case !resp.IsSuccess():
+		return fmt.Errorf("could not read the application version %s: %s", fullName, resp.Status())
+	case len(appver.Key) == 0:
+		return fmt.Errorf("could not read the application version %s: no key returned", fullName)
+	}
+
+	if appver.Components == nil {
+		appver.Components = model.NewComponents()
+	}
+
+	// A new version of the component replaces the one already in the application, the versions of a component or variant share the ParentKey
+	components := make([]*model.ComponentVersion, 0)
+	for _, c := range appver.Components.Components {
+		if c.Key == component.Key || (len(component.ParentKey) > 0 && c.ParentKey == component.ParentKey) {
+			slog.Info("replacing the component version", "old", c.Name, "new", component.Name, "appver", fullName)
+			continue
+		}
+		components = append(components, c)
+	}
+	appver.Components.Components = append(components, component)
+
+	// POST Struct, default is JSON content type. No need to set one
+	var res model.ResponseKey
+	resp, err = client.R().
+		SetBody(appver).
+		SetResult(&res).
+		Post("http://localhost:8080/msapi/appver")
+
+	logUpload("appver", fullName, resp, err, res.Key)
+
+	if err != nil {
+		return fmt.Errorf("could not attach %s to %s: %v", component.Name, fullName, err)
+	}
+
+	if !resp.IsSuccess() {
+		return fmt.Errorf("could not attach %s to %s: %s", component.Name, fullName, resp.Status())
+	}
+	return nil
+}
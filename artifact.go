@@ -0,0 +1,276 @@
+// Package main - Non image artifacts listed in component.toml: binaries, archives, npm packages and Helm charts
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anchore/syft/syft"
+	"github.com/anchore/syft/syft/format/cyclonedxjson"
+	"github.com/anchore/syft/syft/source"
+	"github.com/anchore/syft/syft/source/directorysource"
+	"github.com/anchore/syft/syft/source/filesource"
+	toml "github.com/pelletier/go-toml"
+)
+
+// artifactCompTypes maps the artifact types in component.toml to the component version CompType
+var artifactCompTypes = map[string]string{
+	"image":   "docker",
+	"docker":  "docker",
+	"binary":  "binary",
+	"archive": "archive",
+	"npm":     "npm",
+	"helm":    "helm",
+	"file":    "file",
+}
+
+// componentArtifact is an [[Artifact]] entry in component.toml
+type componentArtifact struct {
+	File     string `toml:"File"`     // Path or glob of the artifact, ${var} references are resolved
+	Type     string `toml:"Type"`     // binary, archive, npm, helm or file.  Detected from the content when empty.
+	Name     string `toml:"Name"`     // Defaults to the file name
+	Required bool   `toml:"Required"` // Fail the run when the glob matches no file, otherwise the artifact is skipped with a warning
+}
+
+// artifactEvidence is an artifact with its digests and SBOM
+type artifactEvidence struct {
+	componentArtifact
+	SHA256 string
+	SHA512 string
+	SBOM   string // CycloneDX json from the Syft catalog
+}
+
+// readArtifacts returns the [[Artifact]] entries of component.toml with the ${var} references resolved and the globs expanded
+func readArtifacts(filename string) ([]componentArtifact, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var doc struct {
+		Artifact []componentArtifact `toml:"Artifact"`
+	}
+
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse the artifacts in %s: %v", filename, err)
+	}
+
+	var vars map[interface{}]interface{}
+	toml.Unmarshal(data, &vars)
+
+	artifacts := make([]componentArtifact, 0)
+	for _, a := range doc.Artifact {
+		pattern := resolveVars(a.File, vars)
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(matches) == 0 {
+			if a.Required {
+				return nil, fmt.Errorf("artifact %s not found", pattern)
+			}
+			slog.Warn("skipping the artifact, no file matches", "artifact", pattern)
+			continue
+		}
+
+		for _, match := range matches {
+			artifact := componentArtifact{File: match, Type: strings.ToLower(a.Type), Name: a.Name, Required: a.Required}
+			if len(artifact.Name) == 0 || len(matches) > 1 {
+				artifact.Name = filepath.Base(match)
+			}
+
+			if len(artifact.Type) == 0 {
+				artifact.Type = detectArtifactType(match)
+			}
+
+			if _, found := artifactCompTypes[artifact.Type]; !found {
+				return nil, fmt.Errorf("artifact %s has unknown type %s", match, artifact.Type)
+			}
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	return artifacts, nil
+}
+
+// tgzContains reports if a gzipped tarball has a file with the base name at the first directory level, ie mychart/Chart.yaml
+func tgzContains(filename string, basename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return false
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return false
+		}
+
+		parts := strings.Split(strings.TrimPrefix(hdr.Name, "./"), "/")
+		if len(parts) == 2 && parts[1] == basename {
+			return true
+		}
+	}
+}
+
+// executableMagic are the ELF, Mach-O and PE headers
+var executableMagic = [][]byte{
+	{0x7f, 'E', 'L', 'F'},
+	{0xfe, 0xed, 0xfa, 0xce},
+	{0xfe, 0xed, 0xfa, 0xcf},
+	{0xce, 0xfa, 0xed, 0xfe},
+	{0xcf, 0xfa, 0xed, 0xfe},
+	{'M', 'Z'},
+}
+
+// detectArtifactType guesses the artifact type from the directory layout, archive contents and file header
+func detectArtifactType(filename string) string {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "file"
+	}
+
+	if info.IsDir() {
+		if _, err := os.Stat(filepath.Join(filename, "Chart.yaml")); err == nil {
+			return "helm"
+		}
+		if _, err := os.Stat(filepath.Join(filename, "package.json")); err == nil {
+			return "npm"
+		}
+		return "archive"
+	}
+
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".tar.gz"):
+		// helm package and npm pack both produce tgz files
+		if tgzContains(filename, "Chart.yaml") {
+			return "helm"
+		}
+		if tgzContains(filename, "package.json") {
+			return "npm"
+		}
+		return "archive"
+	case strings.HasSuffix(lower, ".tar") || strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".jar") || strings.HasSuffix(lower, ".whl"):
+		return "archive"
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "file"
+	}
+	defer f.Close()
+
+	header := make([]byte, 4)
+	n, _ := io.ReadFull(f, header)
+	for _, magic := range executableMagic {
+		if n >= len(magic) && bytes.Equal(header[:len(magic)], magic) {
+			return "binary"
+		}
+	}
+	return "file"
+}
+
+// digestArtifact returns the sha256 and sha512 of a file.  Directories have no digest.
+func digestArtifact(filename string) (string, string, error) {
+	if info, err := os.Stat(filename); err != nil || info.IsDir() {
+		return "", "", err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	h256 := sha256.New()
+	h512 := sha512.New()
+	if _, err := io.Copy(io.MultiWriter(h256, h512), f); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(h256.Sum(nil)), hex.EncodeToString(h512.Sum(nil)), nil
+}
+
+// catalogArtifact catalogs the packages in the file, archive or directory with Syft and returns a CycloneDX SBOM
+func catalogArtifact(filename string) (string, error) {
+	var src source.Source
+	var err error
+
+	if info, statErr := os.Stat(filename); statErr == nil && info.IsDir() {
+		src, err = directorysource.NewFromPath(filename)
+	} else {
+		src, err = filesource.NewFromPath(filename)
+	}
+
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	catalog, err := syft.CreateSBOM(context.Background(), src, syft.DefaultCreateSBOMConfig())
+	if err != nil {
+		return "", err
+	}
+
+	cyclonedx, err := cyclonedxjson.NewFormatEncoderWithConfig(cyclonedxjson.DefaultEncoderConfig())
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := cyclonedx.Encode(buf, *catalog); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// gatherArtifacts computes the digests and SBOM of every artifact
+func gatherArtifacts(artifacts []componentArtifact) []artifactEvidence {
+	evidence := make([]artifactEvidence, 0)
+
+	for _, a := range artifacts {
+		e := artifactEvidence{componentArtifact: a}
+
+		var err error
+		if e.SHA256, e.SHA512, err = digestArtifact(a.File); err != nil {
+			slog.Warn("could not hash the artifact", "file", a.File, "error", err)
+		}
+
+		if e.SBOM, err = catalogArtifact(a.File); err != nil {
+			slog.Warn("could not catalog the artifact", "file", a.File, "error", err)
+		}
+		evidence = append(evidence, e)
+	}
+	return evidence
+}
+
+// artifactCompType returns the CompType of the component, docker when it has an image, otherwise the type of the first artifact
+func artifactCompType(dockerRepo string, artifacts []componentArtifact) string {
+	if len(dockerRepo) > 0 || len(artifacts) == 0 {
+		return "docker"
+	}
+	return artifactCompTypes[artifacts[0].Type]
+}
@@ -0,0 +1,347 @@
+// Package main - Offline verification of the cosign signatures and attestations of the image
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+const (
+	cosignSignatureMediaType  = "application/vnd.dev.cosign.simplesigning.v1+json" // cosignSignatureMediaType is the layer media type of a simple signing payload
+	cosignDSSEMediaType       = "application/vnd.dsse.envelope.v1+json"            // cosignDSSEMediaType is the layer media type of an attestation envelope
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"               // cosignSignatureAnnotation holds the base64 signature of the layer
+	cosignLayoutKind          = "kind"                                             // cosignLayoutKind annotates the manifests of a cosign save layout
+	cosignLayoutSigs          = "dev.cosignproject.cosign/sigs"                    // cosignLayoutSigs is the kind of the signature manifest in a cosign save layout
+	cosignLayoutAtts          = "dev.cosignproject.cosign/atts"                    // cosignLayoutAtts is the kind of the attestation manifest in a cosign save layout
+)
+
+// cosignLayer is a signature or attestation layer with its annotations
+type cosignLayer struct {
+	MediaType   string
+	Annotations map[string]string
+	Data        []byte
+}
+
+// cosignResult is the outcome of the signature and attestation verification
+type cosignResult struct {
+	Verified     bool
+	Signers      []string
+	Signatures   int
+	Attestations []string
+	Errors       []string
+}
+
+// registryKeychain resolves go-containerregistry credentials from the same sources as the image inspection
+type registryKeychain struct {
+	auth *registryAuth
+}
+
+// Resolve returns the credentials for the registry of the resource
+func (k *registryKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	host := target.RegistryStr()
+	if host == name.DefaultRegistry {
+		host = dockerHubAuthHost
+	}
+
+	cfg, err := k.auth.GetAuthConfig(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Username) == 0 && len(cfg.Password) == 0 && len(cfg.IdentityToken) == 0 && len(cfg.RegistryToken) == 0 {
+		return authn.Anonymous, nil
+	}
+
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		IdentityToken: cfg.IdentityToken,
+		RegistryToken: cfg.RegistryToken,
+	}), nil
+}
+
+// readCosignLayers returns the layers of a signature or attestation manifest
+func readCosignLayers(img v1.Image) ([]cosignLayer, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make([]cosignLayer, 0)
+	for _, desc := range manifest.Layers {
+		mediaType := string(desc.MediaType)
+		if mediaType != cosignSignatureMediaType && mediaType != cosignDSSEMediaType {
+			continue
+		}
+
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		rc, err := layer.Compressed()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, cosignLayer{MediaType: mediaType, Annotations: desc.Annotations, Data: data})
+	}
+	return layers, nil
+}
+
+// getCosignLayers fetches the signatures and attestations of the image digest from the .sig and .att tags and the OCI referrers
+func getCosignLayers(argv *argT, repo string, digest string) ([]cosignLayer, error) {
+	host, _, _ := strings.Cut(repo, "/")
+
+	nameOpts := make([]name.Option, 0)
+	for _, h := range splitList(argv.PlainHTTPRegistry) {
+		if h == host {
+			nameOpts = append(nameOpts, name.Insecure)
+		}
+	}
+
+	remoteOpts := []remote.Option{
+		remote.WithContext(context.Background()),
+		remote.WithAuthFromKeychain(&registryKeychain{auth: newRegistryAuth(argv)}),
+	}
+	for _, h := range splitList(argv.InsecureRegistry) {
+		if h == host {
+			transport := remote.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402 -- only for the registries listed in --insecure-registry
+			remoteOpts = append(remoteOpts, remote.WithTransport(transport))
+		}
+	}
+
+	ref, err := name.NewDigest(fmt.Sprintf("%s@sha256:%s", repo, digest), nameOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make([]cosignLayer, 0)
+	seen := make(map[string]bool, 0)
+
+	addImage := func(img v1.Image) error {
+		imgDigest, err := img.Digest()
+		if err != nil || seen[imgDigest.String()] {
+			return err
+		}
+		seen[imgDigest.String()] = true
+
+		found, err := readCosignLayers(img)
+		if err != nil {
+			return err
+		}
+		layers = append(layers, found...)
+		return nil
+	}
+
+	// cosign tag scheme, sha256-<hex>.sig and sha256-<hex>.att
+	for _, suffix := range []string{".sig", ".att"} {
+		tag := ref.Context().Tag("sha256-" + digest + suffix)
+
+		img, err := remote.Image(tag, remoteOpts...)
+		if err != nil {
+			continue
+		}
+
+		if err := addImage(img); err != nil {
+			return nil, err
+		}
+	}
+
+	// OCI 1.1 referrers, go-containerregistry falls back to the referrers tag schema
+	if idx, err := remote.Referrers(ref, remoteOpts...); err == nil {
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, desc := range manifest.Manifests {
+			img, err := remote.Image(ref.Context().Digest(desc.Digest.String()), remoteOpts...)
+			if err != nil {
+				continue
+			}
+
+			if err := addImage(img); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return layers, nil
+}
+
+// getCosignLayersFromLayout reads the signatures and attestations from a cosign save OCI layout
+func getCosignLayersFromLayout(source string) ([]cosignLayer, error) {
+	path, cleanup, err := openLayout(source)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	idx, err := path.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make([]cosignLayer, 0)
+	for _, desc := range manifest.Manifests {
+		if kind := desc.Annotations[cosignLayoutKind]; kind != cosignLayoutSigs && kind != cosignLayoutAtts {
+			continue
+		}
+
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		found, err := readCosignLayers(img)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, found...)
+	}
+	return layers, nil
+}
+
+// verifyCosign checks the signatures and attestations against the trusted keys.  The signed payload has to name the image digest.
+func verifyCosign(layers []cosignLayer, digest string, verifiers []dsse.Verifier) *cosignResult {
+	result := &cosignResult{Signers: make([]string, 0), Attestations: make([]string, 0), Errors: make([]string, 0)}
+	signers := make(map[string]bool, 0)
+	predicates := make(map[string]bool, 0)
+	ctx := context.Background()
+
+	for _, layer := range layers {
+		switch layer.MediaType {
+		case cosignSignatureMediaType:
+			sig, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("invalid signature annotation: %v", err))
+				continue
+			}
+
+			var payload struct {
+				Critical struct {
+					Image struct {
+						DockerManifestDigest string `json:"docker-manifest-digest"`
+					} `json:"image"`
+				} `json:"critical"`
+			}
+			if err := json.Unmarshal(layer.Data, &payload); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("invalid signature payload: %v", err))
+				continue
+			}
+
+			if strings.TrimPrefix(payload.Critical.Image.DockerManifestDigest, "sha256:") != digest {
+				result.Errors = append(result.Errors, fmt.Sprintf("signature is for %s", payload.Critical.Image.DockerManifestDigest))
+				continue
+			}
+
+			trusted := false
+			for _, v := range verifiers {
+				if err := v.Verify(ctx, layer.Data, sig); err == nil {
+					keyID, _ := v.KeyID()
+					signers[keyID] = true
+					result.Signatures++
+					trusted = true
+					break
+				}
+			}
+
+			if !trusted {
+				result.Errors = append(result.Errors, "signature is not from a trusted key")
+			}
+		case cosignDSSEMediaType:
+			envelope, keyIDs, err := verifyEnvelope(layer.Data, verifiers, 1)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("attestation: %v", err))
+				continue
+			}
+
+			payload, err := envelope.DecodeB64Payload()
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("attestation: %v", err))
+				continue
+			}
+
+			var statement struct {
+				PredicateType string `json:"predicateType"`
+				Subject       []struct {
+					Digest map[string]string `json:"digest"`
+				} `json:"subject"`
+			}
+			if err := json.Unmarshal(payload, &statement); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("attestation: %v", err))
+				continue
+			}
+
+			matches := false
+			for _, subject := range statement.Subject {
+				if subject.Digest["sha256"] == digest {
+					matches = true
+				}
+			}
+
+			if !matches {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s attestation does not name sha256:%s", statement.PredicateType, digest))
+				continue
+			}
+
+			for _, keyID := range keyIDs {
+				signers[keyID] = true
+			}
+			predicates[statement.PredicateType] = true
+		}
+	}
+
+	for signer := range signers {
+		result.Signers = append(result.Signers, signer)
+	}
+	for predicate := range predicates {
+		result.Attestations = append(result.Attestations, predicate)
+	}
+	sort.Strings(result.Signers)
+	sort.Strings(result.Attestations)
+
+	result.Verified = result.Signatures > 0 || len(result.Attestations) > 0
+	return result
+}
+
+// cosignAttrs returns the verification outcome as component version attributes
+func cosignAttrs(result *cosignResult) map[string]string {
+	attrs := map[string]string{
+		"COSIGN_VERIFIED":       fmt.Sprintf("%t", result.Verified),
+		"COSIGN_SIGNATURES_CNT": fmt.Sprintf("%d", result.Signatures),
+	}
+
+	if len(result.Signers) > 0 {
+		attrs["COSIGN_SIGNER"] = strings.Join(result.Signers, ",")
+	}
+
+	if len(result.Attestations) > 0 {
+		attrs["COSIGN_ATTESTATIONS"] = strings.Join(result.Attestations, ",")
+	}
+	return attrs
+}
@@ -0,0 +1,363 @@
+// Package main - Deployment records of component versions to an environment, cluster and namespace
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/mkideal/cli"
+	model "github.com/ortelius/scec-commons/model"
+	"gopkg.in/yaml.v3"
+)
+
+// serviceAccountNamespace is the namespace of the pod when the hook runs as a Job in the cluster
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// The labels the Flux kustomize-controller sets on every object it applies, the name is the Kustomization
+const (
+	fluxKustomizationName      = "kustomize.toolkit.fluxcd.io/name"
+	fluxKustomizationNamespace = "kustomize.toolkit.fluxcd.io/namespace"
+)
+
+// deployTarget is where the component version was deployed and which source the values were detected from
+type deployTarget struct {
+	Environment string
+	Cluster     string
+	Namespace   string
+	Source      string // flags, argocd, flux or kubeconfig
+	Revision    string // Git revision the GitOps tool synced, if known
+}
+
+// deployRecordPayload is the body posted to the deployment endpoint.  The cluster, namespace and digest are not part of the model.
+type deployRecordPayload struct {
+	*model.DeploymentDetails
+	Cluster   string      `json:"cluster,omitempty"`
+	Namespace string      `json:"namespace,omitempty"`
+	Digest    string      `json:"digest,omitempty"`
+	Revision  string      `json:"revision,omitempty"`
+	Envelope  interface{} `json:"envelope,omitempty"` // DSSE envelope of the payload without it, when a signing key is set
+}
+
+// kubeConfig is the part of a kubeconfig file needed to resolve the current context
+type kubeConfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// readPodLabels parses the labels file of the downward API, one key="value" per line
+func readPodLabels(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found {
+			continue
+		}
+
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// fluxTarget uses the Kustomization that applied the pod as the environment.  Flux defines no variables for hooks, its labels reach the pod through a downward API volume.
+func fluxTarget(labelsFile string) (deployTarget, bool) {
+	if len(labelsFile) == 0 {
+		return deployTarget{}, false
+	}
+
+	labels, err := readPodLabels(labelsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("could not read the pod labels", "file", labelsFile, "error", err)
+		}
+		return deployTarget{}, false
+	}
+
+	name := labels[fluxKustomizationName]
+	if len(name) == 0 {
+		return deployTarget{}, false
+	}
+	return deployTarget{Environment: name, Namespace: labels[fluxKustomizationNamespace], Source: "flux"}, true
+}
+
+// kubeconfigTarget resolves the context, or the current-context, of the kubeconfig files to its cluster and namespace.  The context name is used as the environment.
+func kubeconfigTarget(kubeconfig string, contextName string) (deployTarget, bool) {
+	files := filepath.SplitList(kubeconfig)
+	if len(files) == 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			files = []string{filepath.Join(home, ".kube", "config")}
+		}
+	}
+
+	// kubectl merges the files, the first file that sets a value wins
+	configs := make([]kubeConfig, 0)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		var cfg kubeConfig
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			slog.Warn("could not parse the kubeconfig", "file", file, "error", err)
+			continue
+		}
+		configs = append(configs, cfg)
+
+		if len(contextName) == 0 {
+			contextName = cfg.CurrentContext
+		}
+	}
+
+	if len(contextName) == 0 {
+		return deployTarget{}, false
+	}
+
+	for _, cfg := range configs {
+		for _, c := range cfg.Contexts {
+			if c.Name == contextName {
+				namespace := c.Context.Namespace
+				if len(namespace) == 0 {
+					namespace = "default"
+				}
+				return deployTarget{Environment: c.Name, Cluster: c.Context.Cluster, Namespace: namespace, Source: "kubeconfig"}, true
+			}
+		}
+	}
+	return deployTarget{}, false
+}
+
+// detectDeployTarget fills the missing environment, cluster and namespace from Argo CD, Flux, the pod service account and the kubeconfig in that order
+func detectDeployTarget(argv *deployRecordT) deployTarget {
+	target := deployTarget{Environment: argv.Environment, Cluster: argv.Cluster, Namespace: argv.Namespace, Source: "flags"}
+
+	fill := func(detected deployTarget) {
+		if len(target.Environment) == 0 && len(detected.Environment) > 0 {
+			target.Environment = detected.Environment
+			target.Source = detected.Source
+		}
+		if len(target.Cluster) == 0 {
+			target.Cluster = detected.Cluster
+		}
+		if len(target.Namespace) == 0 {
+			target.Namespace = detected.Namespace
+		}
+		if len(target.Revision) == 0 {
+			target.Revision = detected.Revision
+		}
+	}
+
+	// Argo CD sets these for config management plugins and hooks, the application usually maps to one environment
+	if app := os.Getenv("ARGOCD_APP_NAME"); len(app) > 0 {
+		fill(deployTarget{
+			Environment: app,
+			Namespace:   os.Getenv("ARGOCD_APP_NAMESPACE"),
+			Revision:    os.Getenv("ARGOCD_APP_REVISION"),
+			Source:      "argocd",
+		})
+	}
+
+	if detected, found := fluxTarget(argv.PodLabels); found {
+		fill(detected)
+	}
+
+	if data, err := os.ReadFile(serviceAccountNamespace); err == nil {
+		fill(deployTarget{Namespace: strings.TrimSpace(string(data))})
+	}
+
+	if detected, found := kubeconfigTarget(argv.Kubeconfig, argv.Context); found {
+		fill(detected)
+	}
+	return target
+}
+
+// deployResult converts success, failure or an exit code to the deployment result, 0 is success
+func deployResult(result string) (int, error) {
+	switch strings.ToLower(result) {
+	case "success", "succeeded", "ok":
+		return 0, nil
+	case "failure", "failed", "error":
+		return 1, nil
+	}
+
+	code, err := strconv.Atoi(result)
+	if err != nil {
+		return 0, fmt.Errorf("unknown result %s, use success, failure or an exit code", result)
+	}
+	return code, nil
+}
+
+type deployRecordT struct {
+	cli.Helper
+	Userid      string `cli:"user" usage:"User id recorded as the deployer" dft:"$USER"`
+	Environment string `cli:"env" usage:"Environment name, detected from Argo CD, the Flux Kustomization or the kubeconfig context when empty"`
+	Cluster     string `cli:"cluster" usage:"Cluster name, detected from the kubeconfig context when empty"`
+	Namespace   string `cli:"namespace" usage:"Namespace, detected from Argo CD, the Flux Kustomization, the pod or the kubeconfig context when empty"`
+	PodLabels   string `cli:"pod-labels" usage:"Downward API labels file of the hook pod, the Flux kustomize.toolkit.fluxcd.io labels are used for the detection" dft:"/etc/podinfo/labels"`
+	Kubeconfig  string `cli:"kubeconfig" usage:"Kubeconfig files used for the detection" dft:"$KUBECONFIG"`
+	Context     string `cli:"context" usage:"Kubeconfig context, defaults to the current-context"`
+	CompVer     string `cli:"compver" usage:"Component version key that was deployed"`
+	Digest      string `cli:"digest" usage:"Image digest that was deployed, sha256:..."`
+	Timestamp   string `cli:"timestamp" usage:"Deploy time, defaults to now"`
+	Result      string `cli:"result" usage:"Deploy result: success, failure or an exit code" dft:"success"`
+	DeployNum   int    `cli:"deploy-num" usage:"Deployment number"`
+	Log         string `cli:"log" usage:"File with the deployment log"`
+
+	LogFlagsT
+	SignFlagsT
+}
+
+// Validate implements cli.Validator interface
+func (argv *deployRecordT) Validate(ctx *cli.Context) error {
+	if len(argv.CompVer) == 0 && len(argv.Digest) == 0 {
+		return fmt.Errorf("--compver or --digest is required")
+	}
+
+	if _, err := deployResult(argv.Result); err != nil {
+		return err
+	}
+	return nil
+}
+
+// recordDeployment posts the deployment of the component version to the detected or given target
+func recordDeployment(argv *deployRecordT) error {
+	signer, closeSigner, err := newEvidenceSigner(&argv.SignFlagsT)
+	if err != nil {
+		return err
+	}
+	defer closeSigner()
+
+	target := detectDeployTarget(argv)
+	if len(target.Environment) == 0 {
+		return fmt.Errorf("no environment, pass --env or run with a kubeconfig context, Argo CD or the Flux labels")
+	}
+
+	deployed := time.Now().UTC()
+	if len(argv.Timestamp) > 0 {
+		t, err := dateparse.ParseAny(argv.Timestamp)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %s: %v", argv.Timestamp, err)
+		}
+		deployed = t.UTC()
+	}
+
+	result, _ := deployResult(argv.Result)
+
+	user := model.NewUser()
+	user.Name, user.Domain = makeName(argv.Userid)
+
+	details := model.NewDeploymentDetails()
+	deployment := details.Deployment
+	deployment.DeployNum = argv.DeployNum
+	deployment.StartTime = deployed
+	deployment.EndTime = deployed
+	deployment.Result = result
+	deployment.Environment.Name = target.Environment
+	if target.Source == "flags" {
+		// Detected names, ie kubeconfig contexts, are not dotted domain paths
+		deployment.Environment.Name, deployment.Environment.Domain = makeName(target.Environment)
+	}
+	deployment.Environment.Creator = user
+	deployment.Environment.Owner = user
+
+	if len(argv.CompVer) > 0 {
+		component := model.NewComponentVersion()
+		component.Key = argv.CompVer
+		deployment.Components.Components = append(deployment.Components.Components, component)
+	}
+
+	// The application version from the [Application] section of component.toml, when the hook runs in the repo
+	if app, err := readApplication("component.toml", map[string]string{}); err == nil && app != nil {
+		deployment.Application.Name, deployment.Application.Domain = makeName(app.Name)
+		deployment.Application.Name += ";" + app.Version
+	}
+
+	details.Log = []string{fmt.Sprintf("Target detected from %s", target.Source)}
+	if len(argv.Log) > 0 {
+		data, err := os.ReadFile(argv.Log)
+		if err != nil {
+			return err
+		}
+		details.Log = append(details.Log, strings.Split(strings.TrimRight(string(data), "\n"), "\n")...)
+	}
+
+	digest := argv.Digest
+	if len(digest) > 0 && !strings.Contains(digest, ":") {
+		digest = "sha256:" + digest
+	}
+
+	slog.Info("recording the deployment", "env", target.Environment, "cluster", target.Cluster, "namespace", target.Namespace, "result", result, "source", target.Source)
+
+	// The deployment endpoint takes the record as is, the signed envelope of the same payload goes beside it
+	payload := deployRecordPayload{DeploymentDetails: details, Cluster: target.Cluster, Namespace: target.Namespace, Digest: digest, Revision: target.Revision}
+	if signer != nil {
+		envelope, err := signPayload(signer, evidencePayloadType("deployment"), payload)
+		if err != nil {
+			return err
+		}
+		payload.Envelope = envelope
+	}
+
+	// POST Struct, default is JSON content type. No need to set one
+	var res model.ResponseKey
+	resp, err := newTracedClient().R().
+		SetBody(payload).
+		SetResult(&res).
+		Post("http://localhost:8080/msapi/deployment")
+
+	logUpload("deployment", target.Environment, resp, err, res.Key)
+	return uploadFailure("deployment", target.Environment, resp, err, res.Key)
+}
+
+var deployCmd = &cli.Command{
+	Name: "deploy",
+	Desc: "Deployment utilities",
+	Fn: func(ctx *cli.Context) error {
+		ctx.WriteUsage()
+		return nil
+	},
+}
+
+var deployRecordCmd = &cli.Command{
+	Name: "record",
+	Desc: "Record the deployment of a component version, run it as a post-deploy hook",
+	Argv: func() interface{} { return new(deployRecordT) },
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*deployRecordT)
+
+		if err := setupLogging(argv.LogLevel, argv.LogFormat); err != nil {
+			return err
+		}
+		addSecrets(argv.PKCS11Pin)
+
+		shutdown, err := setupTracing(argv.OTLPEndpoint, argv.TraceFile)
+		if err != nil {
+			return err
+		}
+		defer shutdown()
+
+		span := startRun("recordDeployment")
+		err = recordDeployment(argv)
+		endSpan(span, err)
+		return err
+	},
+}
@@ -0,0 +1,274 @@
+// Package main - Registry and discovery of the repository documents with the [Files] globs from component.toml
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bmatcuk/doublestar/v4"
+	toml "github.com/pelletier/go-toml"
+)
+
+// documentType is an entry of the document registry.  Adding a type only needs a file type constant and an entry here.
+type documentType struct {
+	Name     string                                                                // Upload file type, ie changelog
+	Keys     []string                                                              // Keys of the [Files] section of component.toml
+	Patterns []string                                                              // Default search order when component.toml has no globs, matching is case-insensitive
+	Upload   bool                                                                  // Uploaded as a typed text file, the license, readme and API specs are part of the component version
+	Extract  func(lines []string, version string) (interface{}, map[string]string) // Structured data and attributes from the content, optional
+}
+
+// docExt matches a text document with or without one of the usual extensions
+const docExt = "{,.md,.markdown,.txt,.rst,.adoc,.org}"
+
+// documentTypes is the registry of the repository documents gathered for the component version
+var documentTypes = map[int]*documentType{
+	LicenseFile: {
+		Name:     "license",
+		Keys:     []string{"LICENSE"},
+		Patterns: []string{"{license,licence,copying,unlicense}" + docExt, "{docs,.github}/{license,licence,copying}" + docExt},
+	},
+	SwaggerFile: {
+		Name: "swagger",
+		Keys: []string{"API", "SWAGGER", "OPENAPI"},
+		Patterns: []string{
+			"{swagger,openapi}.{yaml,yml,json}",
+			"{api,docs,doc,spec,openapi,swagger}/**/{swagger,openapi}.{yaml,yml,json}",
+			"**/{swagger,openapi}.{yaml,yml,json}",
+			"**/*.{swagger,openapi}.{yaml,yml,json}",
+		},
+	},
+	ReadmeFile: {
+		Name:     "readme",
+		Keys:     []string{"README"},
+		Patterns: []string{"readme" + docExt, "{docs,.github}/readme" + docExt},
+	},
+	ChangelogFile: {
+		Name:     "changelog",
+		Keys:     []string{"CHANGELOG"},
+		Patterns: []string{"{changelog,changes,history,news,releases}" + docExt, "docs/{changelog,changes,history,releases}" + docExt},
+		Upload:   true,
+		Extract:  extractChangelog,
+	},
+	SecurityFile: {
+		Name:     "security",
+		Keys:     []string{"SECURITY"},
+		Patterns: []string{".github/security" + docExt, "security" + docExt, "docs/security" + docExt},
+		Upload:   true,
+	},
+	CodeownersFile: {
+		Name:     "codeowners",
+		Keys:     []string{"CODEOWNERS"},
+		Patterns: []string{".github/codeowners", "codeowners", "docs/codeowners", ".gitlab/codeowners"},
+		Upload:   true,
+		Extract:  extractCodeOwners,
+	},
+	ContributingFile: {
+		Name:     "contributing",
+		Keys:     []string{"CONTRIBUTING"},
+		Patterns: []string{".github/contributing" + docExt, "contributing" + docExt, "docs/contributing" + docExt},
+		Upload:   true,
+	},
+}
+
+// skipDirs are never searched, they hold dependencies and tool state rather than the component's own files
+var skipDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	"vendor":       true,
+	"testdata":     true,
+	"third_party":  true,
+}
+
+var (
+	repoFilesMu    sync.Mutex
+	repoFilesCache = make(map[string][]string, 0) // repoFilesCache holds the walk of each directory, every document type searches the same tree
+)
+
+// readFilePatterns returns the globs for the file type from the [Files] section, either an array or a comma separated string
+func readFilePatterns(filename string, filetype int) []string {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+
+	for section, v := range doc {
+		files, ok := v.(map[string]interface{})
+		if !ok || !strings.EqualFold(section, "Files") {
+			continue
+		}
+
+		for k, val := range files {
+			matched := false
+			for _, key := range documentTypes[filetype].Keys {
+				matched = matched || strings.EqualFold(k, key)
+			}
+
+			if !matched {
+				continue
+			}
+
+			patterns := make([]string, 0)
+			switch p := val.(type) {
+			case string:
+				patterns = splitList(p)
+			case []interface{}:
+				for _, item := range p {
+					if s, ok := item.(string); ok {
+						patterns = append(patterns, s)
+					}
+				}
+			}
+			return patterns
+		}
+	}
+	return nil
+}
+
+// repoFiles lists the files under the directory as slash separated relative paths, skipping dependency and hidden directories except .github and .gitlab.
+// The directory is walked once per run, later calls return the cached list.
+func repoFiles(dir string) []string {
+	key, err := filepath.Abs(dir)
+	if err != nil {
+		key = dir
+	}
+
+	repoFilesMu.Lock()
+	defer repoFilesMu.Unlock()
+
+	if files, found := repoFilesCache[key]; found {
+		return files
+	}
+
+	files := make([]string, 0)
+
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if d.IsDir() {
+			name := d.Name()
+			if p != dir && (skipDirs[name] || (strings.HasPrefix(name, ".") && name != ".github" && name != ".gitlab")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if rel, err := filepath.Rel(dir, p); err == nil {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+
+	// Shallow files first so a root README wins over one in a subdirectory
+	sort.SliceStable(files, func(i, j int) bool {
+		di, dj := strings.Count(files[i], "/"), strings.Count(files[j], "/")
+		if di != dj {
+			return di < dj
+		}
+		return files[i] < files[j]
+	})
+
+	repoFilesCache[key] = files
+	return files
+}
+
+// findFiles returns the files of the type in search order.  The [Files] globs from component.toml replace the defaults.
+func findFiles(filetype int) []string {
+	patterns := readFilePatterns("component.toml", filetype)
+	if len(patterns) == 0 {
+		patterns = documentTypes[filetype].Patterns
+	}
+	return matchFiles(repoFiles("."), patterns)
+}
+
+// foldPattern makes the literal letters of the glob match either case, ie README becomes [rR][eE][aA][dD][mM][eE].
+// Character classes and escapes are kept as written, so [A-Z] still only matches upper case letters.
+func foldPattern(pattern string) string {
+	var b strings.Builder
+	runes := []rune(pattern)
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes):
+			b.WriteRune(r)
+			i++
+			b.WriteRune(runes[i])
+		case r == '[':
+			// Copy the class up to its closing bracket, a ] right after [ or [! is part of the class
+			j := i + 1
+			if j < len(runes) && (runes[j] == '!' || runes[j] == '^') {
+				j++
+			}
+			if j < len(runes) && runes[j] == ']' {
+				j++
+			}
+			for j < len(runes) && runes[j] != ']' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				// Unterminated class, left for doublestar to report
+				b.WriteString(string(runes[i:]))
+				return b.String()
+			}
+			b.WriteString(string(runes[i : j+1]))
+			i = j
+		case unicode.ToLower(r) != unicode.ToUpper(r):
+			b.WriteString("[" + string(unicode.ToLower(r)) + string(unicode.ToUpper(r)) + "]")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// inSkipDir reports whether one of the directories of the file is never searched
+func inSkipDir(file string) bool {
+	dirs := strings.Split(file, "/")
+	for _, dir := range dirs[:len(dirs)-1] {
+		if skipDirs[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFiles returns the files matching the globs case-insensitively, in the order of the globs and then of the files.
+// A glob starting with ** does not match inside the skipped directories, name the directory to search it.
+func matchFiles(files []string, patterns []string) []string {
+	found := make([]string, 0)
+	seen := make(map[string]bool, 0)
+
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(strings.TrimPrefix(pattern, "./"))
+		unanchored := strings.HasPrefix(pattern, "**")
+		folded := foldPattern(pattern)
+
+		for _, f := range files {
+			if seen[f] || (unanchored && inSkipDir(f)) {
+				continue
+			}
+
+			if match, err := doublestar.Match(folded, f); err == nil && match {
+				seen[f] = true
+				found = append(found, f)
+			}
+		}
+	}
+	return found
+}
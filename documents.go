@@ -0,0 +1,160 @@
+// Package main - Typed text documents: changelog, security policy, code owners and contributing guide
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// markdownHeading matches an ATX heading, the level is the number of #
+var markdownHeading = regexp.MustCompile(`^(#{1,6})\s*(.*?)\s*#*\s*$`)
+
+// textFile is a repository document uploaded as a typed text file
+type textFile struct {
+	ObjType   string      `json:"objtype"`
+	FileType  string      `json:"filetype"` // Name of the document type, ie changelog
+	Filename  string      `json:"filename"`
+	Content   []string    `json:"content"`
+	Extracted interface{} `json:"extracted,omitempty"` // The changelog entry of the version or the code owner rules
+}
+
+// codeOwnersRule is a CODEOWNERS line, the last matching rule wins
+type codeOwnersRule struct {
+	Pattern string   `json:"pattern"`
+	Owners  []string `json:"owners"`
+}
+
+// changelogEntry returns the section of the changelog whose heading names the version, ie ## [1.2.0] - 2024-05-01 or # v1.2.0
+func changelogEntry(lines []string, version string) []string {
+	version = strings.TrimPrefix(version, "v")
+	if len(version) == 0 {
+		return nil
+	}
+
+	// The version must not be part of a longer one, 1.2.0 does not match 1.2.0.1 or 11.2.0
+	versionToken := regexp.MustCompile(`(^|[^0-9A-Za-z.])v?` + regexp.QuoteMeta(version) + `($|[^0-9A-Za-z.]|\.\s|\.$)`)
+
+	level := 0
+	entry := make([]string, 0)
+	for _, line := range lines {
+		m := markdownHeading.FindStringSubmatch(line)
+
+		if level > 0 {
+			if m != nil && len(m[1]) <= level {
+				break
+			}
+			entry = append(entry, line)
+			continue
+		}
+
+		if m != nil && versionToken.MatchString(m[2]) {
+			level = len(m[1])
+			entry = append(entry, line)
+		}
+	}
+
+	for len(entry) > 0 && len(strings.TrimSpace(entry[len(entry)-1])) == 0 {
+		entry = entry[:len(entry)-1]
+	}
+
+	if len(entry) == 0 {
+		return nil
+	}
+	return entry
+}
+
+// extractChangelog extracts the entry of the component version from the changelog
+func extractChangelog(lines []string, version string) (interface{}, map[string]string) {
+	entry := changelogEntry(lines, version)
+	if entry == nil {
+		if len(version) > 0 {
+			slog.Warn("the changelog has no entry for the version", "version", version)
+		}
+		return nil, map[string]string{"CHANGELOG_HAS_ENTRY": "false"}
+	}
+	return entry, map[string]string{"CHANGELOG_HAS_ENTRY": "true"}
+}
+
+// parseCodeOwners reads the rules of a GitHub or GitLab CODEOWNERS file.  GitLab section headers are skipped.
+func parseCodeOwners(lines []string) []codeOwnersRule {
+	rules := make([]codeOwnersRule, 0)
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "^[") {
+			continue
+		}
+
+		fields := strings.Fields(strings.ReplaceAll(line, `\ `, "\x00"))
+		rules = append(rules, codeOwnersRule{Pattern: strings.ReplaceAll(fields[0], "\x00", " "), Owners: append(make([]string, 0), fields[1:]...)})
+	}
+	return rules
+}
+
+// extractCodeOwners parses the code owner rules and records the owners of the whole repository
+func extractCodeOwners(lines []string, version string) (interface{}, map[string]string) {
+	rules := parseCodeOwners(lines)
+
+	attrs := map[string]string{"CODEOWNERS_RULES_CNT": fmt.Sprintf("%d", len(rules))}
+	if owners := defaultCodeOwners(rules); len(owners) > 0 {
+		attrs["CODEOWNERS_DEFAULT"] = strings.Join(owners, ",")
+	}
+	return rules, attrs
+}
+
+// defaultCodeOwners returns the owners of the last * rule
+func defaultCodeOwners(rules []codeOwnersRule) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].Pattern == "*" || rules[i].Pattern == "/*" || rules[i].Pattern == "/**" || rules[i].Pattern == "**" {
+			return rules[i].Owners
+		}
+	}
+	return nil
+}
+
+// gatherDocuments reads the first file of every uploaded document type.  Returns the text files and the attributes extracted from them.
+func gatherDocuments(version string) ([]textFile, map[string]string) {
+	types := make([]int, 0)
+	for filetype, doc := range documentTypes {
+		if doc.Upload {
+			types = append(types, filetype)
+		}
+	}
+	sort.Ints(types)
+
+	docs := make([]textFile, 0)
+	attrs := make(map[string]string, 0)
+	for _, filetype := range types {
+		doc := documentTypes[filetype]
+
+		files := findFiles(filetype)
+		if len(files) == 0 {
+			continue
+		}
+
+		data, err := os.ReadFile(files[0])
+		if err != nil {
+			slog.Warn("could not read the file", "file", files[0], "error", err)
+			continue
+		}
+
+		tf := textFile{ObjType: "TextFile", FileType: doc.Name, Filename: files[0], Content: strings.Split(string(data), "\n")}
+		if doc.Extract != nil {
+			var extracted map[string]string
+			tf.Extracted, extracted = doc.Extract(tf.Content, version)
+			for k, v := range extracted {
+				attrs[k] = v
+			}
+		}
+		docs = append(docs, tf)
+	}
+	return docs, attrs
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testChangelog = `# Changelog
+
+## [Unreleased]
+
+## [1.2.0.1] - 2024-06-01
+### Fixed
+- patch
+
+## [1.2.0] - 2024-05-01
+### Added
+- feature
+
+## [11.2.0] - 2024-04-01
+- other
+
+## Version 1.1.0.
+- sentence
+
+# v1.0.0
+- first
+`
+
+func TestChangelogEntry(t *testing.T) {
+	lines := strings.Split(testChangelog, "\n")
+
+	tests := []struct {
+		version string
+		want    []string
+	}{
+		{"1.2.0", []string{"## [1.2.0] - 2024-05-01", "### Added", "- feature"}},
+		{"v1.2.0", []string{"## [1.2.0] - 2024-05-01", "### Added", "- feature"}},
+		{"1.2.0.1", []string{"## [1.2.0.1] - 2024-06-01", "### Fixed", "- patch"}},
+		{"11.2.0", []string{"## [11.2.0] - 2024-04-01", "- other"}},
+		{"1.1.0", []string{"## Version 1.1.0.", "- sentence"}},
+		{"1.0.0", []string{"# v1.0.0", "- first"}},
+		{"1.2", nil},
+		{"2.0", nil},
+		{"3.0.0", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := changelogEntry(lines, tt.version); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changelogEntry(%q) = %q, want %q", tt.version, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCodeOwners(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		want     []codeOwnersRule
+		defaults []string
+	}{
+		{
+			name:     "github rules with comments",
+			lines:    []string{"# Owners", "", "*       @org/platform", "/docs/  @jane  # docs team", "*.go    @gophers jane@corp.com"},
+			want:     []codeOwnersRule{{"*", []string{"@org/platform"}}, {"/docs/", []string{"@jane"}}, {"*.go", []string{"@gophers", "jane@corp.com"}}},
+			defaults: []string{"@org/platform"},
+		},
+		{
+			name:  "gitlab sections are skipped",
+			lines: []string{"[Docs] @writers", "docs/ @jane", "^[Optional]", "/api/** @backend"},
+			want:  []codeOwnersRule{{"docs/", []string{"@jane"}}, {"/api/**", []string{"@backend"}}},
+		},
+		{
+			name:  "escaped spaces and a pattern without owners",
+			lines: []string{`/My\ Docs/ @jane`, "/vendor/"},
+			want:  []codeOwnersRule{{"/My Docs/", []string{"@jane"}}, {"/vendor/", []string{}}},
+		},
+		{
+			name:     "the last catch-all rule is the default",
+			lines:    []string{"* @first", "/src/ @src", "/** @last"},
+			want:     []codeOwnersRule{{"*", []string{"@first"}}, {"/src/", []string{"@src"}}, {"/**", []string{"@last"}}},
+			defaults: []string{"@last"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseCodeOwners(tt.lines)
+			if !reflect.DeepEqual(rules, tt.want) {
+				t.Errorf("parseCodeOwners() = %v, want %v", rules, tt.want)
+			}
+
+			if got := defaultCodeOwners(rules); !reflect.DeepEqual(got, tt.defaults) {
+				t.Errorf("defaultCodeOwners() = %q, want %q", got, tt.defaults)
+			}
+		})
+	}
+}
+
+func TestMatchFiles(t *testing.T) {
+	files := []string{
+		"README.md",
+		"docs/Readme.rst",
+		"api/OpenAPI.yaml",
+		"services/web/swagger.json",
+		"vendor/github.com/x/openapi.yaml",
+		"web/node_modules/pkg/swagger.json",
+		"Makefile",
+		"makefile",
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"literals match either case", []string{"readme.md", "docs/README.*"}, []string{"README.md", "docs/Readme.rst"}},
+		{"classes keep their case", []string{"[M]akefile"}, []string{"Makefile"}},
+		{"escaped class", []string{`\[M]akefile`}, []string{}},
+		{"default swagger globs skip dependencies", documentTypes[SwaggerFile].Patterns, []string{"api/OpenAPI.yaml", "services/web/swagger.json"}},
+		{"a named skipped directory is searched", []string{"vendor/**/openapi.yaml"}, []string{"vendor/github.com/x/openapi.yaml"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchFiles(files, tt.patterns); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchFiles(%q) = %q, want %q", tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// Package main - Helm chart inspection for the chart attributes, dependencies and the images the chart deploys
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+)
+
+// relatedComponent is an image or chart the component version deploys or depends on
+type relatedComponent struct {
+	Name     string `json:"name"`
+	Version  string `json:"version,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	CompType string `json:"comptype"`
+}
+
+// inspectChart loads a chart directory or a packaged .tgz chart and returns the images of the chart rendered with its default values.
+// A chart that does not render with the default values, ie a required value, still returns its metadata.
+func inspectChart(chartPath string) (*chart.Chart, []string, error) {
+	ch, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifests, err := renderChart(ch)
+	if err != nil {
+		slog.Warn("could not render the chart with its default values, no images found", "chart", chartPath, "error", err)
+		return ch, []string{}, nil
+	}
+	return ch, chartImages(manifests), nil
+}
+
+// renderChart renders the templates of the chart and its enabled subcharts the way helm install does with no values given
+func renderChart(ch *chart.Chart) (map[string]string, error) {
+	// Processing the dependencies drops the disabled ones from the metadata, the attributes list every declared dependency
+	declared := append([]*chart.Dependency{}, ch.Metadata.Dependencies...)
+	defer func() { ch.Metadata.Dependencies = declared }()
+
+	if err := chartutil.ProcessDependenciesWithMerge(ch, map[string]interface{}{}); err != nil {
+		return nil, err
+	}
+
+	options := chartutil.ReleaseOptions{Name: ch.Name(), Namespace: "default", Revision: 1, IsInstall: true}
+	values, err := chartutil.ToRenderValues(ch, map[string]interface{}{}, options, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, err
+	}
+	return engine.Render(ch, values)
+}
+
+// chartImages returns the sorted image references of the image fields in the rendered manifests
+func chartImages(manifests map[string]string) []string {
+	found := make(map[string]bool, 0)
+
+	for name, manifest := range manifests {
+		if path.Base(name) == "NOTES.txt" {
+			continue
+		}
+
+		dec := yaml.NewDecoder(strings.NewReader(manifest))
+		for {
+			var doc interface{}
+			err := dec.Decode(&doc)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			if err != nil {
+				slog.Debug("skipping the rendered template", "template", name, "error", err)
+				break
+			}
+			collectImages("", doc, found)
+		}
+	}
+
+	images := make([]string, 0, len(found))
+	for image := range found {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// collectImages walks the manifest for image fields, ie the containers, init containers and ephemeral containers of a pod template
+func collectImages(key string, node interface{}, found map[string]bool) {
+	switch v := node.(type) {
+	case string:
+		if ref := strings.TrimSpace(v); key == "image" && len(ref) > 0 {
+			found[ref] = true
+		}
+	case map[string]interface{}:
+		for k, child := range v {
+			collectImages(k, child, found)
+		}
+	case []interface{}:
+		for _, child := range v {
+			collectImages("", child, found)
+		}
+	}
+}
+
+// splitImageRef splits an image reference into the repository, tag and digest
+func splitImageRef(ref string) (string, string, string) {
+	ref, digest, _ := strings.Cut(ref, "@")
+
+	tag := ""
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref, tag = ref[:i], ref[i+1:]
+	}
+	return ref, tag, digest
+}
+
+// chartRelated returns the chart dependencies and the images as related components
+func chartRelated(ch *chart.Chart, images []string) []relatedComponent {
+	related := make([]relatedComponent, 0)
+
+	for _, dep := range ch.Metadata.Dependencies {
+		related = append(related, relatedComponent{Name: dep.Name, Version: dep.Version, CompType: "helm"})
+	}
+
+	for _, image := range images {
+		repo, tag, digest := splitImageRef(image)
+		related = append(related, relatedComponent{Name: repo, Version: tag, Digest: digest, CompType: "docker"})
+	}
+	return related
+}
+
+// chartAttrs returns the appVersion, dependencies and images of the chart as component version attributes
+func chartAttrs(ch *chart.Chart, images []string) map[string]string {
+	attrs := map[string]string{}
+
+	if len(ch.Metadata.AppVersion) > 0 {
+		attrs["CHART_APP_VERSION"] = ch.Metadata.AppVersion
+	}
+
+	deps := make([]string, 0)
+	for _, dep := range ch.Metadata.Dependencies {
+		deps = append(deps, dep.Name+"@"+dep.Version)
+	}
+
+	if len(deps) > 0 {
+		attrs["CHART_DEPENDENCIES"] = strings.Join(deps, ",")
+	}
+
+	if len(images) > 0 {
+		attrs["CHART_IMAGES"] = strings.Join(images, ",")
+	}
+	return attrs
+}
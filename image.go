@@ -0,0 +1,169 @@
+// Package main - Per platform SBOM, provenance and digests for single and multi-platform images
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/buildx/util/imagetools"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// imageTemplate fetches the manifest, image config, SBOM and provenance in one registry round trip.
+// For a multi-platform index .Image, .SBOM and .Provenance are maps keyed by the platform.
+const imageTemplate = `{"manifest":{{ json .Manifest }},"image":{{ json .Image }},"sbom":{{ json .SBOM }},"provenance":{{ json .Provenance }}}`
+
+// unknownPlatform is the platform buildkit uses for the attestation manifests in an index
+const unknownPlatform = "unknown/unknown"
+
+// platformAttestations is the SPDX SBOM and SLSA provenance of one platform manifest of the image
+type platformAttestations struct {
+	Platform   string // os/arch[/variant], ie linux/arm64
+	Digest     string // sha256:... of the platform manifest
+	SBOM       string // SPDX json, same as {{ json .SBOM.SPDX }}
+	Provenance string // {"SLSA": predicate}, same as {{ json .Provenance }}
+}
+
+// platformString formats the os, architecture and variant the same way containerd platforms.Format does
+func platformString(os string, arch string, variant string) string {
+	if len(os) == 0 {
+		return "unknown"
+	}
+
+	parts := []string{os, arch}
+	if len(variant) > 0 {
+		parts = append(parts, variant)
+	}
+	return strings.Join(parts, "/")
+}
+
+// platformKey turns a platform into an attribute name suffix, linux/arm/v7 becomes LINUX_ARM_V7
+func platformKey(platform string) string {
+	return strings.ToUpper(strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(platform))
+}
+
+// getImageAttestations returns the SBOM, provenance and digest for every platform of the image
+func getImageAttestations(src imageSource) (platforms []platformAttestations, err error) {
+	ctx, span := startSpan("image.inspect", attribute.String("image", src.Ref), attribute.String("layout", src.Layout))
+	defer func() {
+		span.SetAttributes(attribute.Int("platforms", len(platforms)))
+		endSpan(span, err)
+	}()
+
+	if len(src.Layout) > 0 {
+		return getAttestationsFromLayout(src.Layout)
+	}
+
+	// Create a new image inspect client.
+	inspectClient, err := imagetools.NewPrinter(ctx, src.Opt, src.Ref, imageTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := inspectClient.Print(false, buf); err != nil {
+		return nil, err
+	}
+	return parseImageInspect(buf.Bytes())
+}
+
+// parseImageInspect splits the imageTemplate output into the platforms.  A single platform image has no maps and uses the image config for the platform.
+func parseImageInspect(data []byte) ([]platformAttestations, error) {
+	type descriptor struct {
+		Digest   string `json:"digest"`
+		Platform *struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	}
+
+	type imageConfig struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	}
+
+	var inspect struct {
+		Manifest struct {
+			descriptor
+			Manifests []descriptor `json:"manifests"`
+		} `json:"manifest"`
+		Image      json.RawMessage `json:"image"`
+		SBOM       json.RawMessage `json:"sbom"`
+		Provenance json.RawMessage `json:"provenance"`
+	}
+
+	if err := json.Unmarshal(data, &inspect); err != nil {
+		return nil, fmt.Errorf("could not read image inspect output: %v", err)
+	}
+
+	var single imageConfig
+	if err := json.Unmarshal(inspect.Image, &single); err == nil && len(single.Architecture) > 0 {
+		var sbom struct {
+			SPDX json.RawMessage `json:"SPDX"`
+		}
+		json.Unmarshal(inspect.SBOM, &sbom)
+
+		pa := platformAttestations{
+			Platform: platformString(single.OS, single.Architecture, single.Variant),
+			Digest:   inspect.Manifest.Digest,
+			SBOM:     string(sbom.SPDX),
+		}
+
+		// The platform manifest digest differs from the index digest for a single platform index
+		for _, m := range inspect.Manifest.Manifests {
+			if m.Platform != nil && platformString(m.Platform.OS, m.Platform.Architecture, m.Platform.Variant) == pa.Platform {
+				pa.Digest = m.Digest
+			}
+		}
+
+		if !bytes.Equal(bytes.TrimSpace(inspect.Provenance), []byte("{}")) {
+			pa.Provenance = string(inspect.Provenance)
+		}
+		return []platformAttestations{pa}, nil
+	}
+
+	sboms := make(map[string]struct {
+		SPDX json.RawMessage `json:"SPDX"`
+	}, 0)
+	provenances := make(map[string]json.RawMessage, 0)
+
+	json.Unmarshal(inspect.SBOM, &sboms)
+	json.Unmarshal(inspect.Provenance, &provenances)
+
+	result := make([]platformAttestations, 0)
+	for _, m := range inspect.Manifest.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+
+		platform := platformString(m.Platform.OS, m.Platform.Architecture, m.Platform.Variant)
+		if platform == unknownPlatform {
+			continue
+		}
+
+		pa := platformAttestations{Platform: platform, Digest: m.Digest, SBOM: string(sboms[platform].SPDX)}
+		if p, found := provenances[platform]; found && !bytes.Equal(bytes.TrimSpace(p), []byte("{}")) {
+			pa.Provenance = string(p)
+		}
+		result = append(result, pa)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Platform < result[j].Platform })
+	return result, nil
+}
+
+// resolveImageDigest resolves a repo:tag reference to the digest of the manifest or index it currently points at, without the sha256: prefix
+func resolveImageDigest(opt imagetools.Opt, imageRef string) (string, error) {
+	ctx, span := startSpan("image.resolve", attribute.String("image", imageRef))
+	_, desc, err := imagetools.New(opt).Resolve(ctx, imageRef)
+	endSpan(span, err)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest.Encoded(), nil
+}
@@ -0,0 +1,343 @@
+// Package main - License identification and compliance policy for the SBOM packages
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/github/go-spdx/v2/spdxexp"
+	"github.com/google/licensecheck"
+	"github.com/mkideal/cli"
+	toml "github.com/pelletier/go-toml"
+)
+
+// licenseMatchThreshold is the minimum percentage of the LICENSE text that has to match the templates
+const licenseMatchThreshold = 75.0
+
+// licensePolicy is the allow/deny policy file.  An empty allow list allows everything that is not denied.
+type licensePolicy struct {
+	Allow         []string `toml:"allow"`
+	Deny          []string `toml:"deny"`
+	FailOnUnknown bool     `toml:"fail_on_unknown"`
+}
+
+// packageLicense is a SBOM package with its SPDX license expression and the policy result
+type packageLicense struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Purl       string `json:"purl,omitempty"`
+	Expression string `json:"expression"`
+	Status     string `json:"status"` // allowed, denied, not-allowed or unknown
+}
+
+// identifyLicense matches the license text against the SPDX license templates and returns the SPDX expression
+func identifyLicense(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	cov := licensecheck.Scan([]byte(strings.Join(lines, "\n")))
+	if cov.Percent < licenseMatchThreshold {
+		return ""
+	}
+
+	ids := make([]string, 0)
+	seen := make(map[string]bool, 0)
+	for _, m := range cov.Match {
+		if !m.IsURL && !seen[m.ID] {
+			seen[m.ID] = true
+			ids = append(ids, m.ID)
+		}
+	}
+	return strings.Join(ids, " AND ")
+}
+
+// readLicensePolicy loads the allow/deny lists from the policy toml file
+func readLicensePolicy(filename string) (*licensePolicy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := new(licensePolicy)
+	if err := toml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("could not parse license policy %s: %v", filename, err)
+	}
+
+	if valid, invalid := spdxexp.ValidateLicenses(append(append([]string{}, policy.Allow...), policy.Deny...)); !valid {
+		return nil, fmt.Errorf("license policy %s has unknown SPDX ids: %s", filename, strings.Join(invalid, ", "))
+	}
+	return policy, nil
+}
+
+// getSBOMLicenses returns the packages and their SPDX license expressions from a CycloneDX or SPDX json SBOM
+func getSBOMLicenses(data []byte) ([]packageLicense, error) {
+	type cdxLicense struct {
+		License struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"license"`
+		Expression string `json:"expression"`
+	}
+
+	var doc struct {
+		Components []json.RawMessage `json:"components"`
+		Packages   []struct {
+			Name             string `json:"name"`
+			VersionInfo      string `json:"versionInfo"`
+			LicenseConcluded string `json:"licenseConcluded"`
+			LicenseDeclared  string `json:"licenseDeclared"`
+			ExternalRefs     []struct {
+				ReferenceType    string `json:"referenceType"`
+				ReferenceLocator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+	}
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	pkgs := make([]packageLicense, 0)
+
+	// CycloneDX components can be nested, multiple license entries all apply to the package
+	var walk func(components []json.RawMessage)
+	walk = func(components []json.RawMessage) {
+		for _, raw := range components {
+			var comp struct {
+				Name       string            `json:"name"`
+				Version    string            `json:"version"`
+				Purl       string            `json:"purl"`
+				Licenses   []cdxLicense      `json:"licenses"`
+				Components []json.RawMessage `json:"components"`
+			}
+
+			if err := json.Unmarshal(raw, &comp); err != nil {
+				continue
+			}
+
+			terms := make([]string, 0)
+			for _, l := range comp.Licenses {
+				switch {
+				case len(l.Expression) > 0:
+					terms = append(terms, "("+l.Expression+")")
+				case len(l.License.ID) > 0:
+					terms = append(terms, l.License.ID)
+				case len(l.License.Name) > 0:
+					terms = append(terms, l.License.Name)
+				}
+			}
+
+			expression := strings.Join(terms, " AND ")
+			if len(terms) == 1 {
+				expression = strings.TrimSuffix(strings.TrimPrefix(terms[0], "("), ")")
+			}
+
+			pkgs = append(pkgs, packageLicense{Name: comp.Name, Version: comp.Version, Purl: comp.Purl, Expression: expression})
+			walk(comp.Components)
+		}
+	}
+	walk(doc.Components)
+
+	for _, p := range doc.Packages {
+		pkg := packageLicense{Name: p.Name, Version: p.VersionInfo}
+
+		for _, ref := range p.ExternalRefs {
+			if ref.ReferenceType == "purl" {
+				pkg.Purl = ref.ReferenceLocator
+			}
+		}
+
+		// Prefer the concluded license over the declared one
+		for _, expression := range []string{p.LicenseConcluded, p.LicenseDeclared} {
+			if len(expression) > 0 && expression != "NOASSERTION" && expression != "NONE" {
+				pkg.Expression = expression
+				break
+			}
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// evaluateLicense checks the expression against the policy and returns allowed, denied, not-allowed or unknown
+func evaluateLicense(expression string, policy *licensePolicy) string {
+	if len(expression) == 0 {
+		return "unknown"
+	}
+
+	ids, err := spdxexp.ExtractLicenses(expression)
+	if err != nil || len(ids) == 0 {
+		return "unknown"
+	}
+
+	// Denied when no choice in the expression avoids the denied licenses
+	if len(policy.Deny) > 0 {
+		denied := make(map[string]bool, len(policy.Deny))
+		for _, id := range policy.Deny {
+			denied[strings.ToLower(id)] = true
+		}
+
+		remaining := make([]string, 0)
+		for _, id := range ids {
+			if !denied[strings.ToLower(strings.TrimSuffix(id, "+"))] {
+				remaining = append(remaining, id)
+			}
+		}
+
+		if len(remaining) == 0 {
+			return "denied"
+		}
+
+		if ok, err := spdxexp.Satisfies(expression, remaining); err != nil {
+			return "unknown"
+		} else if !ok {
+			return "denied"
+		}
+	}
+
+	if len(policy.Allow) > 0 {
+		if ok, err := spdxexp.Satisfies(expression, policy.Allow); err != nil {
+			return "unknown"
+		} else if !ok {
+			return "not-allowed"
+		}
+	}
+	return "allowed"
+}
+
+// checkLicenses evaluates every SBOM package against the policy and returns the packages that violate it
+func checkLicenses(sbomData [][]byte, policy *licensePolicy) ([]packageLicense, []packageLicense) {
+	all := make([]packageLicense, 0)
+	violations := make([]packageLicense, 0)
+	seen := make(map[string]bool, 0)
+
+	for _, data := range sbomData {
+		pkgs, err := getSBOMLicenses(data)
+		if err != nil {
+			slog.Warn("could not read the SBOM licenses", "error", err)
+			continue
+		}
+
+		for _, pkg := range pkgs {
+			id := pkg.Purl
+			if len(id) == 0 {
+				id = pkg.Name + "@" + pkg.Version
+			}
+
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			pkg.Status = evaluateLicense(pkg.Expression, policy)
+			all = append(all, pkg)
+
+			if pkg.Status == "denied" || pkg.Status == "not-allowed" || (pkg.Status == "unknown" && policy.FailOnUnknown) {
+				violations = append(violations, pkg)
+			}
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Name < violations[j].Name })
+	return all, violations
+}
+
+// printLicenseViolations writes the offending packages in a readable list
+func printLicenseViolations(violations []packageLicense) {
+	for _, v := range violations {
+		expression := v.Expression
+		if len(expression) == 0 {
+			expression = "no license"
+		}
+		fmt.Printf("%-11s %s@%s %s (%s)\n", strings.ToUpper(v.Status), v.Name, v.Version, v.Purl, expression)
+	}
+}
+
+// logLicenseViolations logs the offending packages as errors so they land in the run report with the other problems of the run
+func logLicenseViolations(violations []packageLicense) {
+	for _, v := range violations {
+		slog.Error("license policy violation", "status", v.Status, "package", v.Name, "version", v.Version, "purl", v.Purl, "license", v.Expression)
+	}
+}
+
+type licenseCheckT struct {
+	cli.Helper
+	SBOM    string `cli:"*sbom" usage:"CycloneDX or SPDX Json Filename (required)"`
+	Policy  string `cli:"*policy" usage:"License policy toml with allow and deny lists (required)"`
+	License string `cli:"license" usage:"Repository license file, defaults to the LICENSE file in the current directory"`
+	Output  string `cli:"o,output" usage:"Write the json report to this filename"`
+}
+
+var licenseCmd = &cli.Command{
+	Name: "license",
+	Desc: "License utilities",
+	Fn: func(ctx *cli.Context) error {
+		ctx.WriteUsage()
+		return nil
+	},
+}
+
+var licenseCheckCmd = &cli.Command{
+	Name: "check",
+	Desc: "Identify the repository license and check the SBOM package licenses against the policy",
+	Argv: func() interface{} { return new(licenseCheckT) },
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*licenseCheckT)
+
+		policy, err := readLicensePolicy(argv.Policy)
+		if err != nil {
+			return err
+		}
+
+		lines := gatherFile(LicenseFile)
+		if len(argv.License) > 0 {
+			data, err := os.ReadFile(argv.License)
+			if err != nil {
+				return err
+			}
+			lines = strings.Split(string(data), "\n")
+		}
+
+		repoLicense := identifyLicense(lines)
+		if len(repoLicense) > 0 {
+			fmt.Printf("Repository license %s is %s\n", repoLicense, evaluateLicense(repoLicense, policy))
+		}
+
+		data, err := os.ReadFile(argv.SBOM)
+		if err != nil {
+			return err
+		}
+
+		all, violations := checkLicenses([][]byte{data}, policy)
+
+		if len(argv.Output) > 0 {
+			report := map[string]interface{}{
+				"license":    repoLicense,
+				"packages":   all,
+				"violations": violations,
+			}
+
+			out, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return err
+			}
+
+			if err := os.WriteFile(argv.Output, append(out, '\n'), 0o644); err != nil {
+				return err
+			}
+		}
+
+		printLicenseViolations(violations)
+		if len(violations) > 0 {
+			return fmt.Errorf("%d of %d packages violate the license policy %s", len(violations), len(all), argv.Policy)
+		}
+
+		fmt.Printf("%d packages comply with the license policy %s\n", len(all), argv.Policy)
+		return nil
+	},
+}
@@ -3,10 +3,9 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path"
@@ -18,39 +17,30 @@ import (
 	"github.com/anchore/syft/syft/format/spdxjson"
 	"github.com/anchore/syft/syft/sbom"
 	"github.com/araddon/dateparse"
-	"github.com/docker/buildx/util/imagetools"
 	resty "github.com/go-resty/resty/v2"
 	"github.com/mkideal/cli"
 	model "github.com/ortelius/scec-commons/model"
 	toml "github.com/pelletier/go-toml"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	LicenseFile int = 0 // LicenseFile is used to read the License file
-	SwaggerFile int = 1 // SwaggerFile is used to read the Swagger/OpenApi file
-	ReadmeFile  int = 2 // ReadmeFile is used to read the Readme file
+	LicenseFile      int = 0 // LicenseFile is used to read the License file
+	SwaggerFile      int = 1 // SwaggerFile is used to read the Swagger/OpenApi file
+	ReadmeFile       int = 2 // ReadmeFile is used to read the Readme file
+	ChangelogFile    int = 3 // ChangelogFile is used to read the Changelog file
+	SecurityFile     int = 4 // SecurityFile is used to read the security policy
+	CodeownersFile   int = 5 // CodeownersFile is used to read the CODEOWNERS file
+	ContributingFile int = 6 // ContributingFile is used to read the contributing guide
 )
 
-func getSBOMFromImage(imageRef string) string {
-
-	// Create a new context.
-	ctx := context.Background()
-
-	// Create a new image inspect client.
-	var inspectClient *imagetools.Printer
-	var err error
-
-	if inspectClient, err = imagetools.NewPrinter(ctx, imagetools.Opt{}, imageRef, "{{ json .SBOM.SPDX }}"); err != nil {
-		fmt.Printf("Could not load SBOM from image %s: %v", imageRef, err)
-		return ""
-	}
-
-	buf := new(bytes.Buffer)
-	inspectClient.Print(false, buf)
-
+// getSBOMFromImage converts the SPDX SBOM of an image platform to CycloneDX
+func getSBOMFromImage(imageRef string, str string) string {
 	// Convert string to io.Reader
-	str := buf.String()
 	reader := strings.NewReader(str)
+	var err error
 
 	// Decode the image SPDX SBOM
 	var spdxSBOM *sbom.SBOM
@@ -59,43 +49,22 @@ func getSBOMFromImage(imageRef string) string {
 
 	spdxdecoder := spdxjson.NewFormatDecoder()
 	if spdxSBOM, format, version, err = spdxdecoder.Decode(reader); err != nil {
-		fmt.Printf("Could not convert image %s: %v", imageRef, err)
+		slog.Warn("could not convert the image SBOM", "image", imageRef, "error", err)
 		return ""
 	}
-	fmt.Printf("Converted %s from %s %s", imageRef, format, version)
+	slog.Info("converted the image SBOM", "image", imageRef, "format", format, "version", version)
 
 	// Create a CycloneDX Encoder
 	var cyclonedx sbom.FormatEncoder
 
 	if cyclonedx, err = cyclonedxjson.NewFormatEncoderWithConfig(cyclonedxjson.DefaultEncoderConfig()); err != nil {
-		fmt.Printf("Error converting to CycloneDX %s: %v", imageRef, err)
+		slog.Error("could not convert to CycloneDX", "image", imageRef, "error", err)
 		return ""
 	}
 
 	// Convert the SPDX SBOM ot CycloneDX SBOM
-	buf = new(bytes.Buffer)
-	cyclonedx.Encode(buf, *spdxSBOM)
-	return buf.String()
-}
-
-func getProvenanceFromImage(imageRef string) string {
-
-	// Create a new context.
-	ctx := context.Background()
-
-	// Create a new image inspect client.
-	var inspectClient *imagetools.Printer
-	var err error
-
-	if inspectClient, err = imagetools.NewPrinter(ctx, imagetools.Opt{}, imageRef, "{{ json .Provenance }}"); err != nil {
-		fmt.Printf("Could not load Provenance from image %s: %v", imageRef, err)
-		return ""
-	}
-
 	buf := new(bytes.Buffer)
-	inspectClient.Print(false, buf)
-
-	// Convert string to io.Reader
+	cyclonedx.Encode(buf, *spdxSBOM)
 	return buf.String()
 }
 
@@ -106,7 +75,9 @@ func resolveVars(val string, data map[interface{}]interface{}) string {
 		switch t := v.(type) {
 		case map[string]interface{}:
 			for a, b := range t {
-				val = strings.ReplaceAll(val, "${"+a+"}", b.(string))
+				if str, ok := b.(string); ok {
+					val = strings.ReplaceAll(val, "${"+a+"}", str)
+				}
 			}
 		case string:
 			val = strings.ReplaceAll(val, "${"+k.(string)+"}", v.(string))
@@ -237,16 +208,18 @@ func getCompToml(derivedAttrs map[string]string) (*model.CompAttrs, map[string]s
 	f, err := os.ReadFile("component.toml")
 
 	if err != nil {
-		log.Println(err)
+		slog.Warn("could not read component.toml", "error", err)
 		return attrs, extraAttrs
 	}
 
 	var data map[interface{}]interface{}
 
+	_, span := startSpan("toml.parse", attribute.String("file", "component.toml"))
 	err = toml.Unmarshal(f, &data)
+	endSpan(span, err)
 
 	if err != nil {
-		log.Println(err)
+		slog.Error("could not parse component.toml", "error", err)
 		return attrs, extraAttrs
 	}
 
@@ -254,6 +227,11 @@ func getCompToml(derivedAttrs map[string]string) (*model.CompAttrs, map[string]s
 		switch t := v.(type) {
 		case map[string]interface{}:
 			{
+				// The [Application] section names the application version and [Files] the file globs, not attributes of the component
+				if strings.EqualFold(k.(string), "Application") || strings.EqualFold(k.(string), "Files") {
+					continue
+				}
+
 				// Look for well known attributes from component.toml [Attributes] section and assign them
 				for a, b := range t {
 					switch strings.ToUpper(a) {
@@ -370,46 +348,14 @@ func gatherFile(filetype int) []string {
 	lines := make([]string, 0)
 	filename := ""
 
-	if filetype == LicenseFile {
-		if _, err := os.Stat("LICENSE"); err == nil {
-			filename = "LICENSE"
-		} else if _, err := os.Stat("LICENSE.md"); err == nil {
-			filename = "LICENSE.md"
-		} else if _, err := os.Stat("license"); err == nil {
-			filename = "license"
-		} else if _, err := os.Stat("license.md"); err == nil {
-			filename = "license.md"
-		}
-	} else if filetype == SwaggerFile {
-		if _, err := os.Stat("swagger.yaml"); err == nil {
-			filename = "swagger.yaml"
-		} else if _, err := os.Stat("swagger.yml"); err == nil {
-			filename = "swagger.yml"
-		} else if _, err := os.Stat("swagger.json"); err == nil {
-			filename = "swagger.json"
-		} else if _, err := os.Stat("openapi.json"); err == nil {
-			filename = "openapi.json"
-		} else if _, err := os.Stat("openapi.yaml"); err == nil {
-			filename = "openapi.yaml"
-		} else if _, err := os.Stat("openapi.yml"); err == nil {
-			filename = "openapi.yml"
-		}
-	} else if filetype == ReadmeFile {
-		if _, err := os.Stat("README"); err == nil {
-			filename = "README"
-		} else if _, err := os.Stat("README.md"); err == nil {
-			filename = "README.md"
-		} else if _, err := os.Stat("readme"); err == nil {
-			filename = "readme"
-		} else if _, err := os.Stat("readme.md"); err == nil {
-			filename = "readme.md"
-		}
+	if files := findFiles(filetype); len(files) > 0 {
+		filename = files[0]
 	}
 
 	if len(filename) > 0 {
 		data, err := os.ReadFile(filename)
 		if err != nil {
-			log.Println(err)
+			slog.Warn("could not read the file", "file", filename, "error", err)
 			return lines
 		}
 
@@ -422,8 +368,10 @@ func gatherFile(filetype int) []string {
 
 // runGit executes a shell command and returns the output as a string
 func runGit(cmdline string) string {
-	cmd := exec.Command("sh", "-c", cmdline)
-	output, _ := cmd.CombinedOutput()
+	ctx, span := startSpan("runGit", attribute.String("command", redact(cmdline)))
+	cmd := exec.CommandContext(ctx, "sh", "-c", cmdline)
+	output, err := cmd.CombinedOutput()
+	endSpan(span, err)
 
 	return strings.TrimSuffix(string(output), "\n")
 }
@@ -476,15 +424,10 @@ func getDerived() map[string]string {
 
 	mapping["GIT_LINES_TOTAL"] = runGit("wc -l $(git ls-files) | grep total | awk -F' ' '{print $1}'")
 
-	if len(getWithDefault(mapping, "GIT_PREVIOUS_COMPONENT_COMMIT", "")) > 0 {
-		gitcommit := getWithDefault(mapping, "GIT_PREVIOUS_COMPONENT_COMMIT", "")
-		mapping["GIT_LINES_ADDED"] = runGit("git diff --stat " + getWithDefault(mapping, "SHORT_SHA", "") + " " + gitcommit + " | grep changed | cut -d\" \" -f5")
-		mapping["GIT_LINES_DELETED"] = runGit("git diff --stat " + getWithDefault(mapping, "SHORT_SHA", "") + " " + gitcommit + " | grep changed | cut -d\" \" -f7")
-	} else {
-		mapping["GIT_PREVIOUS_COMPONENT_COMMIT"] = ""
-		mapping["GIT_LINES_ADDED"] = "0"
-		mapping["GIT_LINES_DELETED"] = "0"
-	}
+	// The previous component commit and the line deltas come from the previous version, see linkPredecessor
+	mapping["GIT_PREVIOUS_COMPONENT_COMMIT"] = ""
+	mapping["GIT_LINES_ADDED"] = "0"
+	mapping["GIT_LINES_DELETED"] = "0"
 
 	if len(getWithDefault(mapping, "GIT_COMMIT_TIMESTAMP", "")) > 0 {
 		t, _ := dateparse.ParseAny(getWithDefault(mapping, "GIT_COMMIT_TIMESTAMP", ""))
@@ -506,170 +449,766 @@ func getDerived() map[string]string {
 	return mapping
 }
 
-// makeUser takes a string and creates a User struct.  Handles setting the domain if the string contains dots.
+// makeName splits a dotted name into the object name and its domain, see parseName for the quoting rules.  Invalid names are kept as is without a domain.
 func makeName(name string) (string, *model.Domain) {
 	domain := model.NewDomain()
 
-	parts := strings.Split(name, ".")
-	if len(parts) > 1 {
-		name = parts[len(parts)-1]
-		parts = parts[:len(parts)-1]
+	objName, domainName, err := parseName(name)
+	if err != nil {
+		slog.Warn("invalid name", "name", name, "error", err)
+		return name, domain
+	}
+
+	domain.Name = domainName
+	return objName, domain
+}
+
+// compVersionPayload is the body posted to the compver endpoint.  It carries the extra attributes from component.toml and the scans alongside the model.
+type compVersionPayload struct {
+	*model.ComponentVersionDetails
+	ExtraAttrs map[string]string          `json:"extraattrs,omitempty"`
+	Related    []relatedComponent         `json:"related,omitempty"`
+	APISpecs   map[string]json.RawMessage `json:"apispecs,omitempty"` // Every valid API spec by file when the component has more than one
+	VEXKeys    []string                   `json:"vex_keys,omitempty"` // Keys of the VEX documents uploaded for the component version, like sbom_key
+	Envelope   interface{}                `json:"envelope,omitempty"` // DSSE envelope of the payload without it, when a signing key is set
+}
+
+// evidenceUpload is a SBOM or provenance waiting for the license policy check before it is posted
+type evidenceUpload struct {
+	Kind    string      // sbom or provenance, the msapi endpoint
+	Name    string      // File or platform the evidence came from
+	Payload interface{} // model.SBOM or model.Provenance
+	Signed  bool        // The payload is already a signed DSSE envelope
+	SetKey  func(key string)
+}
+
+// uploadEvidence posts the evidence, signed unless it already is, and hands the created key to SetKey
+func uploadEvidence(client *resty.Client, signer *dsse.EnvelopeSigner, upload evidenceUpload) error {
+	body := upload.Payload
+	if !upload.Signed {
+		var err error
+		if body, err = signPayload(signer, evidencePayloadType(upload.Kind), upload.Payload); err != nil {
+			return err
+		}
+	}
+
+	// POST Struct, default is JSON content type. No need to set one
+	var res model.ResponseKey
+	resp, err := client.R().
+		SetBody(body).
+		SetResult(&res).
+		Post("http://localhost:8081/msapi/" + upload.Kind)
+
+	logUpload(upload.Kind, upload.Name, resp, err, res.Key)
 
-		domain.Name = strings.Join(parts, ".")
+	if err := uploadFailure(upload.Kind, upload.Name, resp, err, res.Key); err != nil {
+		return err
 	}
-	return name, domain
+	upload.SetKey(res.Key)
+	return nil
 }
 
-// gatherEvidence collects data from the component.toml and git repo for the component version
-func gatherEvidence(Userid string, Password string, SBOM string) {
+// uploadFailure returns why the POST did not create the object, nil once the server returned its key
+func uploadFailure(kind string, name string, resp *resty.Response, err error, key string) error {
+	switch {
+	case err != nil:
+		return fmt.Errorf("could not upload the %s %s: %v", kind, name, err)
+	case !resp.IsSuccess():
+		return fmt.Errorf("could not upload the %s %s: %s", kind, name, resp.Status())
+	case len(key) == 0:
+		return fmt.Errorf("could not upload the %s %s: no key returned", kind, name)
+	}
+	return nil
+}
 
-	fmt.Printf("%s\n", Password)
+// gatherEvidence collects data from the component.toml and git repo for the component version
+func gatherEvidence(argv *argT) error {
+	endPhase := startPhase("files")
+	defer func() { endPhase() }()
 
 	user := model.NewUser()
 	createTime := time.Now().UTC()
-	user.Name, user.Domain = makeName(Userid)
+	user.Name, user.Domain = makeName(argv.Userid)
 
 	license := model.NewLicense()
 	license.Content = gatherFile(LicenseFile)
 
+	// Every API spec found is validated, only the valid ones are uploaded and the problems are reported instead
 	swagger := model.NewSwagger()
-	swagger.Content = json.RawMessage([]byte(strings.Join(gatherFile(SwaggerFile), "\n")))
+	apiFiles := make([]string, 0)
+	apiSpecs := make(map[string]*apiSpec, 0)
+	for _, filename := range findFiles(SwaggerFile) {
+		var spec *apiSpec
+		data, err := os.ReadFile(filename)
+		if err == nil {
+			spec, err = parseOpenAPI(data)
+		}
+
+		if err != nil {
+			slog.Warn("could not read the API spec", "file", filename, "error", err)
+			continue
+		}
+
+		for _, problem := range spec.Problems {
+			slog.Warn("invalid API spec", "file", filename, "problem", problem)
+		}
+
+		apiFiles = append(apiFiles, filename)
+		apiSpecs[filename] = spec
+	}
+
+	validSpecs := make(map[string]json.RawMessage, 0)
+	provides := make([]string, 0)
+	for _, filename := range apiFiles {
+		if spec := apiSpecs[filename]; len(spec.Problems) == 0 {
+			if len(swagger.Content) == 0 {
+				swagger.Content = spec.Content
+			}
+			validSpecs[filename] = spec.Content
+			provides = append(provides, apiProvides(spec)...)
+		}
+	}
+
+	if len(validSpecs) < 2 {
+		validSpecs = nil
+	}
 
 	readme := model.NewReadme()
 	readme.Content = gatherFile(ReadmeFile)
+	endPhase("license_lines", len(license.Content), "readme_lines", len(readme.Content), "api_specs", len(apiFiles))
 
+	endPhase = startPhase("derive")
 	derivedAttrs := getDerived()
+	endPhase("attrs", len(derivedAttrs))
+
+	endPhase = startPhase("toml")
 	attrs, tomlVars := getCompToml(derivedAttrs)
 
-	//	appname := getWithDefault(tomlVars, "APPLICATION", "")
-	//	appversion := getWithDefault(tomlVars, "APPLICATION_VERSION", "")
+	if spdxID := identifyLicense(license.Content); len(spdxID) > 0 {
+		tomlVars["LICENSE_SPDX"] = spdxID
+	}
+
+	if err := resolveServiceOwner(attrs, tomlVars, argv.OwnerMap, argv.OwnerDays); err != nil {
+		return err
+	}
+
+	// Several specs get the file as attribute suffix, ie API_TITLE_API_V1_OPENAPI_YAML
+	for _, filename := range apiFiles {
+		suffix := ""
+		if len(apiFiles) > 1 {
+			suffix = "_" + platformKey(filename)
+		}
+
+		for k, v := range apiAttrs(apiSpecs[filename]) {
+			tomlVars[k+suffix] = v
+		}
+	}
+
+	if len(apiFiles) > 0 {
+		tomlVars["API_SPECS"] = strings.Join(apiFiles, ",")
+	}
 
 	compver := model.NewComponentVersionDetails()
 
-	compname := getWithDefault(tomlVars, "NAME", "")
-	compvariant := getWithDefault(tomlVars, "VARIANT", "")
-	compversion := getWithDefault(tomlVars, "VERSION", "")
+	// The templates shape the name, they are not attributes of the component version
+	naming := componentNaming{
+		Name:    getWithDefault(tomlVars, "NAME", ""),
+		Variant: getWithDefault(tomlVars, "VARIANT", ""),
+		Version: getWithDefault(tomlVars, "VERSION", ""),
+	}
+
+	compname, compparent, err := composeNames(naming, getWithDefault(tomlVars, "NAMETEMPLATE", ""), getWithDefault(tomlVars, "PARENTNAMETEMPLATE", ""))
+	if err != nil {
+		return err
+	}
+	compversion := naming.Version
+	delete(tomlVars, "NAMETEMPLATE")
+	delete(tomlVars, "PARENTNAMETEMPLATE")
 
-	compbaseversion := compname
-	if len(compvariant) == 0 {
-		compname += ";" + compvariant
-		compbaseversion = compname
+	artifacts, err := readArtifacts("component.toml")
+	if err != nil {
+		return err
+	}
+
+	app, err := readApplication("component.toml", tomlVars)
+	if err != nil {
+		return err
 	}
 
-	if len(compversion) == 0 {
-		compname += ";" + compversion
+	if app != nil {
+		tomlVars["APPLICATION"] = app.Name
+		tomlVars["APPLICATION_VERSION"] = app.Version
+	}
+
+	// Helm charts fill the chart attributes and list the images they deploy as related components.  CHART may also name a local chart.
+	chartPaths := make([]string, 0)
+	if _, err := os.Stat(attrs.Chart); len(attrs.Chart) > 0 && err == nil {
+		chartPaths = append(chartPaths, attrs.Chart)
+		attrs.Chart = ""
+	}
+	for _, artifact := range artifacts {
+		if artifact.Type == "helm" {
+			chartPaths = append(chartPaths, artifact.File)
+		}
+	}
+
+	related := make([]relatedComponent, 0)
+	for _, chartPath := range chartPaths {
+		chart, images, err := inspectChart(chartPath)
+		if err != nil {
+			slog.Warn("could not inspect the chart", "chart", chartPath, "error", err)
+			continue
+		}
+
+		if len(attrs.Chart) == 0 {
+			attrs.Chart = chart.Metadata.Name
+		}
+		if len(attrs.ChartVersion) == 0 {
+			attrs.ChartVersion = chart.Metadata.Version
+		}
+
+		for k, v := range chartAttrs(chart, images) {
+			tomlVars[k] = v
+		}
+		related = append(related, chartRelated(chart, images)...)
 	}
 
 	compver.Attrs = attrs
-	compver.CompType = "docker"
+	compver.CompType = artifactCompType(attrs.DockerRepo, artifacts)
 	compver.Created = createTime
 	compver.Creator = user
 	compver.License = license
-	compver.Name, compver.Domain = makeName(compname)
-	compver.Owner.Name, compver.Owner.Domain = makeName(Userid)
-	compver.ParentKey = compbaseversion
+	compver.Name, compver.Domain.Name, err = componentName(compname, getWithDefault(tomlVars, "DOMAIN", ""))
+	if err != nil {
+		return fmt.Errorf("invalid component name %s: %v", compname, err)
+	}
+
+	parentName, parentDomain, err := componentName(compparent, getWithDefault(tomlVars, "DOMAIN", ""))
+	if err != nil {
+		return fmt.Errorf("invalid parent name %s: %v", compparent, err)
+	}
+	compver.Owner.Name, compver.Owner.Domain = makeName(argv.Userid)
 	compver.Readme = readme
+	if len(provides) > 0 {
+		compver.Providing.Provides = provides
+	}
 	compver.Swagger = swagger
 
-	client := resty.New()
+	signer, closeSigner, err := newEvidenceSigner(&argv.SignFlagsT)
+	if err != nil {
+		return err
+	}
+	defer closeSigner()
+
+	client := newTracedClient()
+	sbomData := make([][]byte, 0)
+	uploads := make([]evidenceUpload, 0) // Posted once the SBOMs pass the license policy
+
+	if !argv.SkipDomainCheck {
+		if err := checkDomain(client, compver.Domain.Name); err != nil {
+			return err
+		}
+	}
+
+	// Link the new version to the variant, or the component when there is no variant, it was made from
+	compver.ParentKey = lookupComponentKey(client, qualifiedName(parentDomain, parentName))
+	if len(compver.ParentKey) == 0 {
+		slog.Info("no parent version found", "parent", qualifiedName(parentDomain, parentName))
+	}
+
+	if err := linkPredecessor(client, compver, qualifiedName(parentDomain, parentName), argv.Force); err != nil {
+		return err
+	}
+	endPhase("name", qualifiedName(compver.Domain.Name, compver.Name))
+	trace.SpanFromContext(runCtx).SetAttributes(attribute.String("compver.name", qualifiedName(compver.Domain.Name, compver.Name)))
+	recordComponent(qualifiedName(compver.Domain.Name, compver.Name), compversion, derivedAttrs)
+
+	if _, err := os.Stat(argv.SBOM); err == nil {
+		if data, err := os.ReadFile(argv.SBOM); err == nil {
+			sbomData = append(sbomData, data)
 
-	if _, err := os.Stat(SBOM); err == nil {
-		if data, err := os.ReadFile(SBOM); err == nil {
 			sbom := model.NewSBOM()
 			sbom.Content = json.RawMessage(data)
 
-			// POST Struct, default is JSON content type. No need to set one
-			var res model.ResponseKey
-			resp, err := client.R().
-				SetBody(sbom).
-				SetResult(&res).
-				Post("http://localhost:8081/msapi/sbom")
+			uploads = append(uploads, evidenceUpload{Kind: "sbom", Name: argv.SBOM, Payload: sbom, SetKey: func(key string) {
+				compver.SBOMKey = key
+			}})
+		}
+	}
 
-			fmt.Printf("%s=%v\n", resp, err)
-			fmt.Printf("KEY=%s\n", res.Key)
+	// Binaries, archives, npm packages and Helm charts are hashed and cataloged instead of inspecting an image
+	endPhase = startPhase("artifacts")
+	artifactFiles := make([]string, 0)
+	artifactNames := make([]string, 0)
+	for _, artifact := range gatherArtifacts(artifacts) {
+		suffix := platformKey(artifact.Name)
+		artifactFiles = append(artifactFiles, artifact.File)
+		artifactNames = append(artifactNames, artifact.Name)
+
+		tomlVars["ARTIFACT_TYPE_"+suffix] = artifact.Type
+		if len(artifact.SHA256) > 0 {
+			tomlVars["ARTIFACT_SHA256_"+suffix] = artifact.SHA256
+			tomlVars["ARTIFACT_SHA512_"+suffix] = artifact.SHA512
+		}
 
-			compver.SBOMKey = res.Key
+		if len(artifact.SBOM) == 0 {
+			continue
 		}
+
+		sbomData = append(sbomData, []byte(artifact.SBOM))
+
+		sbom := model.NewSBOM()
+		sbom.Content = json.RawMessage(artifact.SBOM)
+
+		uploads = append(uploads, evidenceUpload{Kind: "sbom", Name: artifact.Name, Payload: sbom, SetKey: func(key string) {
+			tomlVars["SBOM_KEY_"+suffix] = key
+			if len(compver.SBOMKey) == 0 {
+				compver.SBOMKey = key
+			}
+		}})
 	}
 
+	if len(artifactNames) > 0 {
+		tomlVars["ARTIFACTS"] = strings.Join(artifactNames, ",")
+	}
+	endPhase("artifacts", len(artifactNames))
+
+	endPhase = startPhase("image")
 	imageRef := ""
-	if len(attrs.DockerRepo) > 0 {
+	platforms := make([]platformAttestations, 0)
+	imageProvenance := false
+	if (len(attrs.DockerRepo) > 0 || len(argv.ImageSource) > 0) && compver.CompType == "docker" {
+		opt := newImageOpt(argv)
+		attrs.DockerSha = strings.TrimPrefix(attrs.DockerSha, "sha256:")
+
+		// Tags are mutable, pin the evidence to the digest the tag points at right now
+		if len(attrs.DockerRepo) > 0 && len(attrs.DockerTag) > 0 && len(argv.ImageSource) == 0 {
+			tagRef := fmt.Sprintf("%s:%s", attrs.DockerRepo, attrs.DockerTag)
+
+			if digest, err := resolveImageDigest(opt, tagRef); err != nil {
+				slog.Warn("could not resolve the image digest", "image", tagRef, "error", err)
+			} else if len(attrs.DockerSha) == 0 {
+				slog.Info("resolved the image digest", "image", tagRef, "digest", "sha256:"+digest)
+				attrs.DockerSha = digest
+			} else if attrs.DockerSha != digest {
+				slog.Warn("the image tag moved, using DockerSha", "image", tagRef, "digest", "sha256:"+digest, "docker_sha", "sha256:"+attrs.DockerSha)
+			}
+		}
+
 		if len(attrs.DockerSha) > 0 {
 			imageRef = fmt.Sprintf("%s@sha256:%s", attrs.DockerRepo, attrs.DockerSha)
 		} else if len(attrs.DockerTag) > 0 {
 			imageRef = fmt.Sprintf("%s:%s", attrs.DockerRepo, attrs.DockerTag)
 		}
 
-		sbomString := getSBOMFromImage(imageRef)
+		src := imageSource{Ref: imageRef, Layout: argv.ImageSource, Opt: opt}
+		if len(src.Layout) > 0 {
+			imageRef = src.Layout
+		}
 
-		if len(sbomString) > 0 {
-			sbom := model.NewSBOM()
-			sbom.Content = json.RawMessage(sbomString)
+		var err error
+		if platforms, err = getImageAttestations(src); err != nil {
+			slog.Warn("could not load the SBOM and provenance from the image", "image", imageRef, "error", err)
+		}
+
+		// Multi-platform images get a SBOM and provenance per platform, the first platform is the one on the component version.
+		// The image SBOM replaces the one from the file or artifacts, the image uploads are queued after theirs.
+		platformNames := make([]string, 0)
+		imageSBOMKey := ""
+		for _, platform := range platforms {
+			platformNames = append(platformNames, platform.Platform)
+			suffix := platformKey(platform.Platform)
+			tomlVars["PLATFORM_DIGEST_"+suffix] = platform.Digest
+
+			sbomString := ""
+			if len(platform.SBOM) > 0 {
+				sbomString = getSBOMFromImage(imageRef+" "+platform.Platform, platform.SBOM)
+			}
+
+			if len(sbomString) > 0 {
+				sbomData = append(sbomData, []byte(sbomString))
+
+				sbom := model.NewSBOM()
+				sbom.Content = json.RawMessage(sbomString)
+
+				uploads = append(uploads, evidenceUpload{Kind: "sbom", Name: platform.Platform, Payload: sbom, SetKey: func(key string) {
+					tomlVars["SBOM_KEY_"+suffix] = key
+					if len(imageSBOMKey) == 0 {
+						imageSBOMKey = key
+						compver.SBOMKey = key
+					}
+				}})
+			}
+
+			if len(platform.Provenance) > 0 {
+				// Refuse provenance built from a different repository or commit than the one being recorded
+				facts, err := parseProvenance(platform.Provenance)
+				if err != nil {
+					slog.Warn("could not parse the provenance", "platform", platform.Platform, "error", err)
+				} else {
+					if err := verifyProvenance(facts, attrs.GitURL, attrs.GitCommit); err != nil {
+						return fmt.Errorf("%s %s: %v", imageRef, platform.Platform, err)
+					}
+
+					if _, found := tomlVars["SLSA_LEVEL"]; !found {
+						for k, v := range provenanceAttrs(facts) {
+							tomlVars[k] = v
+						}
+					}
+				}
+
+				provenance := model.NewProvenance()
+				provenance.Content = json.RawMessage(platform.Provenance)
+				imageProvenance = true
+
+				uploads = append(uploads, evidenceUpload{Kind: "provenance", Name: platform.Platform, Payload: provenance, SetKey: func(key string) {
+					tomlVars["PROVENANCE_KEY_"+suffix] = key
+					if len(compver.ProvenanceKey) == 0 {
+						compver.ProvenanceKey = key
+					}
+				}})
+			}
+		}
+
+		if len(platformNames) > 0 {
+			tomlVars["PLATFORMS"] = strings.Join(platformNames, ",")
+		}
+	}
+
+	// Check the image was signed by a trusted key before recording it, fully offline without a transparency log
+	if len(argv.CosignKey) > 0 {
+		verifiers, err := loadPublicKeys(splitList(argv.CosignKey))
+		if err != nil {
+			return err
+		}
+
+		digest := attrs.DockerSha
+		if len(digest) == 0 && len(platforms) == 1 {
+			digest = strings.TrimPrefix(platforms[0].Digest, "sha256:")
+		}
+
+		var layers []cosignLayer
+		_, span := startSpan("image.signatures", attribute.String("image", imageRef))
+		if len(argv.ImageSource) > 0 {
+			layers, err = getCosignLayersFromLayout(argv.ImageSource)
+		} else if len(attrs.DockerRepo) > 0 && len(digest) > 0 {
+			layers, err = getCosignLayers(argv, attrs.DockerRepo, digest)
+		}
+		span.SetAttributes(attribute.Int("layers", len(layers)))
+		endSpan(span, err)
+
+		if err != nil {
+			slog.Warn("could not load the image signatures", "image", imageRef, "error", err)
+		}
+
+		result := verifyCosign(layers, digest, verifiers)
+		for _, msg := range result.Errors {
+			slog.Warn("image signature", "problem", msg)
+		}
+
+		for k, v := range cosignAttrs(result) {
+			tomlVars[k] = v
+		}
+
+		// Provenance signed by the release key is SLSA Build L2
+		if level, found := tomlVars["SLSA_LEVEL"]; found {
+			tomlVars["SLSA_LEVEL"] = verifiedSLSALevel(level, result.Attestations)
+		}
+
+		if !result.Verified && argv.RequireSignature {
+			return fmt.Errorf("%s is not signed by a trusted key", imageRef)
+		}
+		slog.Info("image signature", "verified", result.Verified, "signers", strings.Join(result.Signers, ","))
+	}
+
+	// Builds without buildx have no provenance, describe the build from the git repository and CI environment instead
+	if argv.GenerateProvenance && !imageProvenance {
+		subjects, err := provenanceSubjects(attrs.DockerRepo, attrs.DockerSha, platforms, append(splitList(argv.Subject), artifactFiles...))
+		if err != nil {
+			return err
+		}
+
+		content, err := generateProvenance(subjects, attrs.GitURL, getWithDefault(derivedAttrs, "GIT_COMMIT", ""), attrs.GitBranch, createTime)
+		if err != nil {
+			return err
+		}
+
+		if facts, err := parseProvenance(string(content)); err == nil {
+			for k, v := range provenanceAttrs(facts) {
+				tomlVars[k] = v
+			}
+		}
+
+		if argv.ProvenanceDSSE {
+			if content, err = wrapDSSE(content, signer); err != nil {
+				return err
+			}
+		}
+
+		provenance := model.NewProvenance()
+		provenance.Content = json.RawMessage(content)
 
-			// POST Struct, default is JSON content type. No need to set one
-			var res model.ResponseKey
-			resp, err := client.R().
-				SetBody(sbom).
-				SetResult(&res).
-				Post("http://localhost:8081/msapi/sbom")
+		// The DSSE envelope already carries the signature, signing it again would nest a second envelope
+		uploads = append(uploads, evidenceUpload{Kind: "provenance", Name: "generated", Payload: provenance, Signed: argv.ProvenanceDSSE, SetKey: func(key string) {
+			compver.ProvenanceKey = key
+		}})
+		tomlVars["SLSA_GENERATED"] = "true"
+	}
+
+	endPhase("image", imageRef, "platforms", len(platforms))
+
+	// Every SBOM is collected, fail before the first upload when the SBOM packages violate the license policy
+	if len(argv.LicensePolicy) > 0 {
+		policy, err := readLicensePolicy(argv.LicensePolicy)
+		if err != nil {
+			return err
+		}
+
+		all, violations := checkLicenses(sbomData, policy)
+		logLicenseViolations(violations)
+
+		if len(violations) > 0 {
+			return fmt.Errorf("%d of %d packages violate the license policy %s", len(violations), len(all), argv.LicensePolicy)
+		}
+	}
+
+	// Attach the vulnerability summary for the SBOM packages to the extra attributes
+	if len(argv.OSVDB) > 0 && len(sbomData) > 0 {
+		findings := make([]vulnFinding, 0)
+
+		for _, data := range sbomData {
+			if found, err := scanSBOM(data, argv.OSVDB); err == nil {
+				findings = append(findings, found...)
+			} else {
+				slog.Warn("could not scan the SBOM", "error", err)
+			}
+		}
+
+		for k, v := range vulnSummary(findings) {
+			tomlVars[k] = v
+		}
+		slog.Info("vulnerability scan", "findings", len(findings))
+	}
 
-			fmt.Printf("%s=%v\n", resp, err)
-			fmt.Printf("KEY=%s\n", res.Key)
+	endPhase = startPhase("upload")
 
-			compver.SBOMKey = res.Key
+	for _, upload := range uploads {
+		if err := uploadEvidence(client, signer, upload); err != nil {
+			return err
 		}
+	}
 
-		provenanceString := getProvenanceFromImage(imageRef)
+	// Upload the VEX documents found next to component.toml, the VEX key in component.toml overrides the search list
+	vexPatterns := make([]string, 0)
+	if len(getWithDefault(tomlVars, "VEX", "")) > 0 {
+		vexPatterns = strings.Split(getWithDefault(tomlVars, "VEX", ""), ",")
+	}
 
-		if len(provenanceString) > 0 {
-			provenance := model.NewProvenance()
-			provenance.Content = json.RawMessage(provenanceString)
+	vexKeys := make([]string, 0)
+	for _, vex := range loadVEXFiles(vexPatterns, sbomData) {
 
-			// POST Struct, default is JSON content type. No need to set one
-			var res model.ResponseKey
-			resp, err := client.R().
-				SetBody(provenance).
-				SetResult(&res).
-				Post("http://localhost:8081/msapi/provenance")
+		// POST Struct, default is JSON content type. No need to set one
+		var res model.ResponseKey
+		resp, err := client.R().
+			SetBody(vex).
+			SetResult(&res).
+			Post("http://localhost:8081/msapi/vex")
 
-			fmt.Printf("%s=%v\n", resp, err)
-			fmt.Printf("KEY=%s\n", res.Key)
+		logUpload("vex", vex.Format, resp, err, res.Key)
 
-			compver.ProvenanceKey = res.Key
+		if err := uploadFailure("vex", vex.Format, resp, err, res.Key); err != nil {
+			return err
 		}
+		vexKeys = append(vexKeys, res.Key)
+	}
 
+	// Upload the changelog, security policy, code owners and contributing guide as typed text files
+	docs, docAttrs := gatherDocuments(compversion)
+	for k, v := range docAttrs {
+		tomlVars[k] = v
+	}
+
+	for _, doc := range docs {
+		// POST Struct, default is JSON content type. No need to set one
+		var res model.ResponseKey
+		resp, err := client.R().
+			SetBody(doc).
+			SetResult(&res).
+			Post("http://localhost:8081/msapi/textfile")
+
+		logUpload(doc.FileType, doc.Filename, resp, err, res.Key)
+
+		if err := uploadFailure(doc.FileType, doc.Filename, resp, err, res.Key); err != nil {
+			return err
+		}
+		tomlVars[strings.ToUpper(doc.FileType)+"_KEY"] = res.Key
+	}
+
+	// The compver endpoint takes the model as is, the signed envelope of the same payload goes beside it
+	payload := compVersionPayload{ComponentVersionDetails: compver, ExtraAttrs: tomlVars, Related: related, APISpecs: validSpecs, VEXKeys: vexKeys}
+	if signer != nil {
+		envelope, err := signPayload(signer, evidencePayloadType("compver"), payload)
+		if err != nil {
+			return err
+		}
+		payload.Envelope = envelope
 	}
 
 	// POST Struct, default is JSON content type. No need to set one
+	var res model.ResponseKey
 	resp, err := client.R().
-		SetBody(compver).
+		SetBody(payload).
+		SetResult(&res).
 		Post("http://localhost:8080/msapi/compver")
 
-	fmt.Printf("%s=%v\n", resp, err)
+	logUpload("compver", qualifiedName(compver.Domain.Name, compver.Name), resp, err, res.Key)
 
-	// b, err := json.Marshal(compver)
-	//
-	//	if err != nil {
-	//		fmt.Println(err)
-	//		return
-	//	}
-	//
-	// fmt.Println(string(b))
-}
+	recordKey("SBOM_KEY", compver.SBOMKey)
+	recordKey("PROVENANCE_KEY", compver.ProvenanceKey)
 
-// main is the entrypoint for the CLI.  Takes --user and --pass parameters
-func main() {
-	type argT struct {
-		cli.Helper
-		Userid   string `cli:"*user" usage:"User id (required)"`
-		Password string `cli:"*pass" usage:"User password (required)"`
-		SBOM     string `cli:"sbom" usage:"CycloneDX Json Filename"`
+	// The component version is the result of the run, the run fails without it
+	switch {
+	case err != nil:
+		return fmt.Errorf("could not create the component version %s: %v", qualifiedName(compver.Domain.Name, compver.Name), err)
+	case !resp.IsSuccess():
+		return fmt.Errorf("could not create the component version %s: %s", qualifiedName(compver.Domain.Name, compver.Name), resp.Status())
+	case len(res.Key) == 0:
+		return fmt.Errorf("could not create the component version %s: no key returned", qualifiedName(compver.Domain.Name, compver.Name))
 	}
+	recordKey("COMPVER_KEY", res.Key)
+
+	// Attach the new component version to the application version from the [Application] section
+	if app != nil {
+		component := model.NewComponentVersion()
+		component.Key = res.Key
+		component.Name = compver.Name
+		component.Domain = compver.Domain
+		component.ParentKey = compver.ParentKey
+
+		if err := attachToApplication(client, app, component, user, createTime); err != nil {
+			return err
+		}
+	}
+
+	endPhase("compver_key", res.Key)
+	trace.SpanFromContext(runCtx).SetAttributes(attribute.String("compver.key", res.Key))
+	return nil
+}
+
+// LogFlagsT defines the logging and tracing flags shared by the commands that post to the server.  It is exported so the cli package can fill the embedded fields.
+type LogFlagsT struct {
+	LogLevel  string `cli:"log-level" usage:"Log level: debug, info, warn or error" dft:"info"`
+	LogFormat string `cli:"log-format" usage:"Log format: text or json" dft:"text"`
+
+	OTLPEndpoint string `cli:"otlp-endpoint" usage:"OTLP/HTTP endpoint the traces are exported to, ie http://localhost:4318" dft:"$OTEL_EXPORTER_OTLP_ENDPOINT"`
+	TraceFile    string `cli:"trace-file" usage:"File the traces are written to as JSON lines for offline use"`
+}
+
+// SignFlagsT defines the key file or PKCS#11 token flags shared by the commands that sign what they post
+type SignFlagsT struct {
+	SignKey      string `cli:"sign-key" usage:"PEM ed25519, ECDSA or RSA private key used to sign the evidence as DSSE envelopes"`
+	PKCS11Module string `cli:"pkcs11-module" usage:"PKCS#11 module holding the ECDSA or RSA signing key, ie /usr/lib/softhsm/libsofthsm2.so"`
+	PKCS11Token  string `cli:"pkcs11-token" usage:"PKCS#11 token label, defaults to the first token"`
+	PKCS11Label  string `cli:"pkcs11-label" usage:"PKCS#11 key label"`
+	PKCS11Pin    string `cli:"pkcs11-pin" usage:"PKCS#11 user pin" dft:"$PKCS11_PIN"`
+}
+
+// argT defines the flags for gathering the evidence for a component version
+type argT struct {
+	cli.Helper
+	Userid   string `cli:"*user" usage:"User id (required)"`
+	Password string `cli:"*pass" usage:"User password (required)"`
+	SBOM     string `cli:"sbom" usage:"CycloneDX Json Filename"`
+	OSVDB    string `cli:"osv-db" usage:"OSV database directory used to add the vulnerability summary to the component version"`
+
+	LicensePolicy string `cli:"license-policy" usage:"License policy toml, the run fails when SBOM packages use denied licenses"`
+
+	SkipDomainCheck bool `cli:"skip-domain-check" usage:"Do not check that the component domain exists on the server"`
+	Force           bool `cli:"force" usage:"Record the component version even when it or its commit already exists"`
+
+	LogFlagsT
+
+	Report      string `cli:"report" usage:"File the JSON run report is written to"`
+	StepSummary string `cli:"step-summary" usage:"File the markdown run summary is appended to" dft:"$GITHUB_STEP_SUMMARY"`
+	Dotenv      string `cli:"dotenv" usage:"File the SBOM_KEY, PROVENANCE_KEY and COMPVER_KEY are written to as a GitLab dotenv report"`
+	JUnit       string `cli:"junit" usage:"File the JUnit XML report is written to, a test case for every evidence upload"`
+
+	OwnerMap  string `cli:"owner-map" usage:"Toml mapping git emails and CODEOWNERS handles to Ortelius users and notification channels" dft:"$OWNER_MAP"`
+	OwnerDays int    `cli:"owner-days" usage:"Days of git history used to find the ServiceOwner when there is no owner or CODEOWNERS rule" dft:"90"`
 
-	os.Exit(cli.Run(new(argT), func(ctx *cli.Context) error {
+	Registry          string `cli:"registry" usage:"Registry host the explicit credentials apply to, defaults to every registry"`
+	RegistryUser      string `cli:"registry-user" usage:"Registry user name, overrides the docker config and credential helpers" dft:"$REGISTRY_USER"`
+	RegistryToken     string `cli:"registry-token" usage:"Registry password or token" dft:"$REGISTRY_TOKEN"`
+	InsecureRegistry  string `cli:"insecure-registry" usage:"Comma separated registry hosts with self signed or invalid TLS certificates"`
+	PlainHTTPRegistry string `cli:"plain-http-registry" usage:"Comma separated registry hosts served over plain HTTP, ie localhost:5000"`
+	ImageSource       string `cli:"image-source" usage:"Read the image from an OCI layout directory or docker save tarball instead of the registry"`
+
+	GenerateProvenance bool   `cli:"generate-provenance" usage:"Generate SLSA v1 provenance from git and the CI environment when the image has none"`
+	ProvenanceDSSE     bool   `cli:"provenance-dsse" usage:"Wrap the generated provenance in an in-toto DSSE envelope, signed by the signing key when one is set"`
+	Subject            string `cli:"subject" usage:"Comma separated artifact file globs added to the generated provenance subjects"`
+
+	SignFlagsT
+
+	CosignKey        string `cli:"cosign-key" usage:"Comma separated public keys used to verify the cosign signatures and attestations of the image"`
+	RequireSignature bool   `cli:"require-signature" usage:"Fail when the image has no signature or attestation from the cosign keys"`
+}
+
+var rootCmd = &cli.Command{
+	Name:        "scec-cli",
+	Desc:        "Ortelius CLI for adding Component Versions to the DB from the CI/CD pipeline",
+	Argv:        func() interface{} { return new(argT) },
+	CanSubRoute: true,
+	Fn: func(ctx *cli.Context) error {
 		argv := ctx.Argv().(*argT)
 
-		gatherEvidence(argv.Userid, argv.Password, argv.SBOM)
-		return nil
-	}))
+		if err := setupLogging(argv.LogLevel, argv.LogFormat); err != nil {
+			return err
+		}
+		addSecrets(argv.Password, argv.RegistryToken, argv.PKCS11Pin)
+
+		shutdown, err := setupTracing(argv.OTLPEndpoint, argv.TraceFile)
+		if err != nil {
+			return err
+		}
+		defer shutdown()
+
+		span := startRun("gatherEvidence")
+		err = gatherEvidence(argv)
+		endSpan(span, err)
+
+		finishReport(err)
+		writeReports(argv)
+		return err
+	},
+}
+
+// main is the entrypoint for the CLI.  Without a sub-command it takes --user and --pass parameters and gathers the evidence
+func main() {
+	setupLogging("info", "text")
+
+	root := cli.Root(rootCmd,
+		cli.Tree(sbomCmd,
+			cli.Tree(sbomNormalizeCmd),
+			cli.Tree(sbomRebuildCmd),
+		),
+		cli.Tree(vulnCmd,
+			cli.Tree(vulnScanCmd),
+		),
+		cli.Tree(vexCmd,
+			cli.Tree(vexGenerateCmd),
+			cli.Tree(vexValidateCmd),
+		),
+		cli.Tree(licenseCmd,
+			cli.Tree(licenseCheckCmd),
+		),
+		cli.Tree(verifyCmd),
+		cli.Tree(deployCmd,
+			cli.Tree(deployRecordCmd),
+		),
+	)
+
+	if err := root.Run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
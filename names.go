@@ -0,0 +1,164 @@
+// Package main - Parsing and validation of dotted domain paths and object names
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+// splitDomainPath splits on the dots outside double quotes and not escaped with a backslash.  Reports if quoting or escaping was used.
+func splitDomainPath(path string) ([]string, bool, error) {
+	segments := make([]string, 0)
+	var current strings.Builder
+
+	inQuote, escaped, quoted := false, false, false
+	for _, r := range path {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, quoted = true, true
+		case r == '"':
+			inQuote, quoted = !inQuote, true
+		case r == '.' && !inQuote:
+			segments = append(segments, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if inQuote {
+		return nil, quoted, fmt.Errorf("unterminated quote in %s", path)
+	}
+
+	if escaped {
+		return nil, quoted, fmt.Errorf("trailing backslash in %s", path)
+	}
+	return append(segments, current.String()), quoted, nil
+}
+
+// formatDomain joins the domain segments, quoting the ones that contain a dot
+func formatDomain(segments []string) string {
+	parts := make([]string, 0, len(segments))
+	for _, s := range segments {
+		if strings.Contains(s, ".") {
+			s = `"` + s + `"`
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ".")
+}
+
+// validateSegment checks a domain or object name against the naming rules: not empty, no surrounding spaces, no control characters and no ; which separates the variant and version
+func validateSegment(kind string, segment string) error {
+	if len(strings.TrimSpace(segment)) == 0 {
+		return fmt.Errorf("empty %s", kind)
+	}
+
+	if strings.TrimSpace(segment) != segment {
+		return fmt.Errorf("%s %q has leading or trailing spaces", kind, segment)
+	}
+
+	for _, r := range segment {
+		if unicode.IsControl(r) || r == ';' || r == '"' || r == '\\' {
+			return fmt.Errorf("%s %q contains the invalid character %q", kind, segment, r)
+		}
+	}
+	return nil
+}
+
+// parseName splits a full name into the object name and the domain path.
+//   - GLOBAL.Store.checkout is checkout in the GLOBAL.Store domain
+//   - GLOBAL.Store."my.service" and GLOBAL.Store.my\.service are my.service in the GLOBAL.Store domain
+//   - everything from the first ; on, the variant and version, is part of the name so x;v1.2.3 keeps its dots
+//   - an unquoted name with an @ is an email, jane.doe@corp.com has no domain
+func parseName(full string) (string, string, error) {
+	base, suffix := full, ""
+	inQuote := false
+	for i, r := range full {
+		if r == '"' {
+			inQuote = !inQuote
+		}
+		if r == ';' && !inQuote {
+			base, suffix = full[:i], full[i:]
+			break
+		}
+	}
+
+	segments, quoted, err := splitDomainPath(base)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !quoted && strings.Contains(base, "@") {
+		return full, "", validateSegment("name", base)
+	}
+
+	name := segments[len(segments)-1]
+	if err := validateSegment("name", name); err != nil {
+		return "", "", err
+	}
+
+	domains := segments[:len(segments)-1]
+	for _, d := range domains {
+		if err := validateSegment("domain", d); err != nil {
+			return "", "", fmt.Errorf("%s: %v", full, err)
+		}
+	}
+	return name + suffix, formatDomain(domains), nil
+}
+
+// componentName applies the Domain key of component.toml.  With a Domain the name is taken literally after removing the domain prefix, so dots need no quoting.
+func componentName(full string, domain string) (string, string, error) {
+	if len(domain) == 0 {
+		return parseName(full)
+	}
+
+	segments, _, err := splitDomainPath(domain)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, d := range segments {
+		if err := validateSegment("domain", d); err != nil {
+			return "", "", fmt.Errorf("%s: %v", domain, err)
+		}
+	}
+
+	name := strings.TrimPrefix(full, domain+".")
+	base, _, _ := strings.Cut(name, ";")
+	if err := validateSegment("name", base); err != nil {
+		return "", "", err
+	}
+	return name, formatDomain(segments), nil
+}
+
+// checkDomain makes sure the domain exists on the server.  An unreachable server is only a warning so offline runs still work.
+func checkDomain(client *resty.Client, domain string) error {
+	if len(domain) == 0 {
+		return nil
+	}
+
+	resp, err := client.R().Get("http://localhost:8080/msapi/domain/" + url.PathEscape(domain))
+	if err != nil {
+		slog.Warn("could not check the domain", "domain", domain, "error", err)
+		return nil
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("domain %s does not exist", domain)
+	}
+
+	if !resp.IsSuccess() {
+		slog.Warn("could not check the domain", "domain", domain, "status", resp.Status())
+	}
+	return nil
+}
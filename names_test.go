@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		full    string
+		name    string
+		domain  string
+		wantErr bool
+	}{
+		{full: "checkout", name: "checkout"},
+		{full: "GLOBAL.Store.checkout", name: "checkout", domain: "GLOBAL.Store"},
+		{full: `GLOBAL.Store."my.service"`, name: "my.service", domain: "GLOBAL.Store"},
+		{full: `GLOBAL.Store.my\.service`, name: "my.service", domain: "GLOBAL.Store"},
+		{full: `GLOBAL."acme.com".api`, name: "api", domain: `GLOBAL."acme.com"`},
+		{full: `GLOBAL.acme\.com.api`, name: "api", domain: `GLOBAL."acme.com"`},
+		{full: "GLOBAL.Store.x;v1.2.3", name: "x;v1.2.3", domain: "GLOBAL.Store"},
+		{full: `GLOBAL.Store."x;y".z;v1`, wantErr: true},
+		{full: "jane.doe@corp.com", name: "jane.doe@corp.com"},
+		{full: "jane.doe@corp.com;v1.0", name: "jane.doe@corp.com;v1.0"},
+		{full: `GLOBAL.Users."jane.doe@corp.com"`, name: "jane.doe@corp.com", domain: "GLOBAL.Users"},
+		{full: `GLOBAL.Users.jane\.doe@corp\.com`, name: "jane.doe@corp.com", domain: "GLOBAL.Users"},
+		{full: `GLOBAL."Store`, wantErr: true},
+		{full: `GLOBAL.Store\`, wantErr: true},
+		{full: "GLOBAL..checkout", wantErr: true},
+		{full: "GLOBAL. Store.checkout", wantErr: true},
+		{full: "GLOBAL.Store.", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.full, func(t *testing.T) {
+			name, domain, err := parseName(tt.full)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseName() = %q, %q, want an error", name, domain)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name != tt.name || domain != tt.domain {
+				t.Errorf("parseName() = %q, %q, want %q, %q", name, domain, tt.name, tt.domain)
+			}
+		})
+	}
+}
+
+func TestComponentName(t *testing.T) {
+	tests := []struct {
+		full    string
+		domain  string
+		name    string
+		domOut  string
+		wantErr bool
+	}{
+		{full: "GLOBAL.Store.checkout", name: "checkout", domOut: "GLOBAL.Store"},
+		{full: "GLOBAL.Store.my.service", domain: "GLOBAL.Store", name: "my.service", domOut: "GLOBAL.Store"},
+		{full: "my.service;v1.0.2", domain: "GLOBAL.Store", name: "my.service;v1.0.2", domOut: "GLOBAL.Store"},
+		{full: "checkout", domain: `GLOBAL."acme.com"`, name: "checkout", domOut: `GLOBAL."acme.com"`},
+		{full: `GLOBAL."acme.com".api.v2`, domain: `GLOBAL."acme.com"`, name: "api.v2", domOut: `GLOBAL."acme.com"`},
+		{full: "jane.doe@corp.com", domain: `GLOBAL.acme\.com`, name: "jane.doe@corp.com", domOut: `GLOBAL."acme.com"`},
+		{full: "checkout", domain: "GLOBAL..Store", wantErr: true},
+		{full: "checkout", domain: `GLOBAL."Store`, wantErr: true},
+		{full: "GLOBAL.Store.", domain: "GLOBAL.Store", wantErr: true},
+		{full: `bad"name`, domain: "GLOBAL.Store", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain+"/"+tt.full, func(t *testing.T) {
+			name, domain, err := componentName(tt.full, tt.domain)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("componentName() = %q, %q, want an error", name, domain)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name != tt.name || domain != tt.domOut {
+				t.Errorf("componentName() = %q, %q, want %q, %q", name, domain, tt.name, tt.domOut)
+			}
+		})
+	}
+}
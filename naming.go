@@ -0,0 +1,126 @@
+// Package main - Composition of the component version name from the name, variant and version
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"text/template"
+
+	resty "github.com/go-resty/resty/v2"
+	model "github.com/ortelius/scec-commons/model"
+)
+
+const (
+	defaultNameTemplate       = `{{.Name}}{{with .Variant}};{{.}}{{end}};{{.Version}}` // defaultNameTemplate gives name;variant;version, or name;version without a variant
+	defaultParentNameTemplate = `{{.Name}}{{with .Variant}};{{.}}{{end}}`              // defaultParentNameTemplate names the version the new version hangs off, the variant or the component itself
+)
+
+// componentNaming holds the parts of the component version name available to the NameTemplate and ParentNameTemplate in component.toml
+type componentNaming struct {
+	Name    string
+	Variant string
+	Version string
+}
+
+// validate checks that the name and version are set and that no ${var} was left unresolved
+func (n componentNaming) validate() error {
+	if len(strings.TrimSpace(n.Name)) == 0 {
+		return fmt.Errorf("the component Name is empty")
+	}
+
+	if len(strings.TrimSpace(n.Version)) == 0 {
+		return fmt.Errorf("the component Version is empty, set Version in component.toml")
+	}
+
+	for field, value := range map[string]string{"Name": n.Name, "Variant": n.Variant, "Version": n.Version} {
+		if strings.Contains(value, "${") {
+			return fmt.Errorf("the component %s %s has an unresolved variable", field, value)
+		}
+	}
+	return nil
+}
+
+// render executes the name template
+func (n componentNaming) render(text string) (string, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid name template %s: %v", text, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, n); err != nil {
+		return "", fmt.Errorf("invalid name template %s: %v", text, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// composeNames returns the full name of the component version and of its parent.  Empty templates use the defaults.
+func composeNames(naming componentNaming, nameTemplate string, parentTemplate string) (string, string, error) {
+	if err := naming.validate(); err != nil {
+		return "", "", err
+	}
+
+	if len(nameTemplate) == 0 {
+		nameTemplate = defaultNameTemplate
+	}
+
+	if len(parentTemplate) == 0 {
+		parentTemplate = defaultParentNameTemplate
+	}
+
+	name, err := naming.render(nameTemplate)
+	if err != nil {
+		return "", "", err
+	}
+
+	parent, err := naming.render(parentTemplate)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Every version needs its own name
+	unversioned := naming
+	unversioned.Version = ""
+	if other, _ := unversioned.render(nameTemplate); other == name {
+		return "", "", fmt.Errorf("the name template %s does not include the version", nameTemplate)
+	}
+	return name, parent, nil
+}
+
+// qualifiedName prefixes the name with its domain, quoting a name that contains dots so parseName reads it back the same way
+func qualifiedName(domain string, name string) string {
+	base, suffix, found := strings.Cut(name, ";")
+	if strings.Contains(base, ".") {
+		base = `"` + base + `"`
+	}
+
+	if found {
+		base += ";" + suffix
+	}
+
+	if len(domain) == 0 {
+		return base
+	}
+	return domain + "." + base
+}
+
+// lookupComponentKey returns the key of the component version with the full name, empty when the server does not know it
+func lookupComponentKey(client *resty.Client, fullName string) string {
+	compver := model.NewComponentVersionDetails()
+	resp, err := client.R().
+		SetResult(compver).
+		Get("http://localhost:8080/msapi/compver/" + url.PathEscape(fullName))
+
+	if err != nil {
+		slog.Warn("could not look up the component version", "compver", fullName, "error", err)
+		return ""
+	}
+
+	if !resp.IsSuccess() {
+		return ""
+	}
+	return compver.Key
+}
@@ -0,0 +1,114 @@
+package main
+
+import "testing"
+
+func TestComposeNames(t *testing.T) {
+	tests := []struct {
+		name           string
+		naming         componentNaming
+		nameTemplate   string
+		parentTemplate string
+		full           string
+		parent         string
+		wantErr        bool
+	}{
+		{
+			name:   "default templates",
+			naming: componentNaming{Name: "checkout", Version: "1.2.3"},
+			full:   "checkout;1.2.3",
+			parent: "checkout",
+		},
+		{
+			name:   "default templates with a variant",
+			naming: componentNaming{Name: "checkout", Variant: "main", Version: "1.2.3"},
+			full:   "checkout;main;1.2.3",
+			parent: "checkout;main",
+		},
+		{
+			name:           "custom templates",
+			naming:         componentNaming{Name: "checkout", Variant: "main", Version: "1.2.3"},
+			nameTemplate:   "{{.Name}}-{{.Variant}};v{{.Version}}",
+			parentTemplate: "{{.Name}}-{{.Variant}}",
+			full:           "checkout-main;v1.2.3",
+			parent:         "checkout-main",
+		},
+		{
+			name:         "template without a version",
+			naming:       componentNaming{Name: "checkout", Variant: "main", Version: "1.2.3"},
+			nameTemplate: "{{.Name}};{{.Variant}}",
+			wantErr:      true,
+		},
+		{
+			name:         "template that only uses the version when there is no variant",
+			naming:       componentNaming{Name: "checkout", Variant: "main", Version: "1.2.3"},
+			nameTemplate: "{{.Name}};{{if .Variant}}{{.Variant}}{{else}}{{.Version}}{{end}}",
+			wantErr:      true,
+		},
+		{
+			name:         "unknown template field",
+			naming:       componentNaming{Name: "checkout", Version: "1.2.3"},
+			nameTemplate: "{{.Name}};{{.Release}}",
+			wantErr:      true,
+		},
+		{
+			name:         "unparsable template",
+			naming:       componentNaming{Name: "checkout", Version: "1.2.3"},
+			nameTemplate: "{{.Name};{{.Version}}",
+			wantErr:      true,
+		},
+		{
+			name:    "empty version",
+			naming:  componentNaming{Name: "checkout", Version: " "},
+			wantErr: true,
+		},
+		{
+			name:    "unresolved variable",
+			naming:  componentNaming{Name: "checkout", Version: "${BUILDNUM}"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			full, parent, err := composeNames(tt.naming, tt.nameTemplate, tt.parentTemplate)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("composeNames() = %q, %q, want an error", full, parent)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if full != tt.full || parent != tt.parent {
+				t.Errorf("composeNames() = %q, %q, want %q, %q", full, parent, tt.full, tt.parent)
+			}
+		})
+	}
+}
+
+func TestQualifiedName(t *testing.T) {
+	tests := []struct {
+		domain string
+		name   string
+		want   string
+	}{
+		{"GLOBAL.Store", "checkout;1.2.3", "GLOBAL.Store.checkout;1.2.3"},
+		{"GLOBAL.Store", "my.service;1.2.3", `GLOBAL.Store."my.service";1.2.3`},
+		{"", "my.service", `"my.service"`},
+	}
+
+	for _, tt := range tests {
+		got := qualifiedName(tt.domain, tt.name)
+		if got != tt.want {
+			t.Errorf("qualifiedName(%q, %q) = %q, want %q", tt.domain, tt.name, got, tt.want)
+		}
+
+		// parseName reads the qualified name back into the same name and domain
+		if name, domain, err := parseName(got); err != nil || name != tt.name || domain != tt.domain {
+			t.Errorf("parseName(%q) = %q, %q, %v, want %q, %q", got, name, domain, err, tt.name, tt.domain)
+		}
+	}
+}
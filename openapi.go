@@ -0,0 +1,385 @@
+// Package main - Swagger 2.0 and OpenAPI 3.x parsing, validation and conversion to canonical JSON
+package main
+
+import (
+	_ "embed" // The official JSON Schemas of the spec versions
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	//go:embed schemas/openapi-2.0.json
+	swaggerSchema string
+	//go:embed schemas/openapi-3.0.json
+	openAPI30Schema string
+	//go:embed schemas/openapi-3.1.json
+	openAPI31Schema string
+	//go:embed schemas/json-schema-draft-04.json
+	draft04Schema string // The swagger 2.0 schema refers to the definitions of the draft-04 meta schema
+)
+
+// openAPISchemas are the official JSON Schemas by spec version, the urls are their $id
+var openAPISchemas = map[string]struct{ URL, Schema string }{
+	"2.0": {"http://swagger.io/v2/schema.json", swaggerSchema},
+	"3.0": {"https://spec.openapis.org/oas/3.0/schema/2021-09-28", openAPI30Schema},
+	"3.1": {"https://spec.openapis.org/oas/3.1/schema/2022-10-07", openAPI31Schema},
+}
+
+var (
+	compiledMu      sync.Mutex
+	compiledSchemas = make(map[string]*jsonschema.Schema, 0) // compiledSchemas caches the compiled openAPISchemas
+)
+
+// apiMethods are the operations of a path item in the order they are listed
+var apiMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openAPIVersion matches the supported 3.0 and 3.1 spec versions
+var openAPIVersion = regexp.MustCompile(`^3\.[01]\.\d+$`)
+
+// pathParam matches the {name} templates in a path
+var pathParam = regexp.MustCompile(`{([^}]+)}`)
+
+// apiEndpoint is an operation of the API
+type apiEndpoint struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	OperationID string `json:"operationId,omitempty"`
+	Summary     string `json:"summary,omitempty"`
+}
+
+// apiSpec is a parsed Swagger or OpenAPI document
+type apiSpec struct {
+	SpecVersion string          // 2.0, 3.0.3, 3.1.0 ...
+	Title       string          // info.title
+	Version     string          // info.version as written, 1.0 stays 1.0
+	Endpoints   []apiEndpoint   // Sorted by path and method
+	Problems    []string        // Validation problems, the spec should not be uploaded when there are any
+	Content     json.RawMessage // Canonical JSON, keys sorted and no whitespace
+}
+
+// normalizeYAML converts the yaml.v3 values to values encoding/json accepts.  Non string keys like response codes become strings.
+func normalizeYAML(node interface{}) interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = normalizeYAML(child)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			m[fmt.Sprint(k)] = normalizeYAML(child)
+		}
+		return m
+	case []interface{}:
+		for i, child := range v {
+			v[i] = normalizeYAML(child)
+		}
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return node
+}
+
+// yamlScalar returns the text of the scalar at the keys as written in the document, ie 1.0 for an unquoted version that decodes as the number 1
+func yamlScalar(node *yaml.Node, keys ...string) string {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			return ""
+		}
+
+		var value *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				value = node.Content[i+1]
+			}
+		}
+
+		if value == nil {
+			return ""
+		}
+		node = value
+	}
+
+	if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+		return ""
+	}
+	return node.Value
+}
+
+// parseOpenAPI reads a YAML or JSON Swagger/OpenAPI document, validates it and converts it to canonical JSON
+func parseOpenAPI(data []byte) (*apiSpec, error) {
+	var root yaml.Node
+	var node interface{}
+
+	// JSON is a subset of YAML so one parser reads both
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("could not parse the API spec: %v", err)
+	}
+
+	if err := root.Decode(&node); err != nil {
+		return nil, fmt.Errorf("could not parse the API spec: %v", err)
+	}
+
+	doc, ok := normalizeYAML(node).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the API spec is not an object")
+	}
+
+	content, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &apiSpec{Content: content, Endpoints: make([]apiEndpoint, 0)}
+
+	// The versions are kept as written, the schema validation reports the ones that are not strings
+	spec.SpecVersion = yamlScalar(&root, "swagger")
+	if len(spec.SpecVersion) == 0 {
+		spec.SpecVersion = yamlScalar(&root, "openapi")
+	}
+
+	spec.Title = yamlScalar(&root, "info", "title")
+	spec.Version = yamlScalar(&root, "info", "version")
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	for path, item := range paths {
+		ops, _ := item.(map[string]interface{})
+		for _, method := range apiMethods {
+			op, ok := ops[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			endpoint := apiEndpoint{Method: strings.ToUpper(method), Path: path}
+			endpoint.OperationID, _ = op["operationId"].(string)
+			endpoint.Summary, _ = op["summary"].(string)
+			spec.Endpoints = append(spec.Endpoints, endpoint)
+		}
+	}
+
+	sort.SliceStable(spec.Endpoints, func(i, j int) bool {
+		if spec.Endpoints[i].Path != spec.Endpoints[j].Path {
+			return spec.Endpoints[i].Path < spec.Endpoints[j].Path
+		}
+		return methodIndex(spec.Endpoints[i].Method) < methodIndex(spec.Endpoints[j].Method)
+	})
+
+	spec.Problems = validateOpenAPI(doc, content)
+	return spec, nil
+}
+
+// methodIndex orders the methods the same way as apiMethods
+func methodIndex(method string) int {
+	for i, m := range apiMethods {
+		if strings.EqualFold(m, method) {
+			return i
+		}
+	}
+	return len(apiMethods)
+}
+
+// declaredPathParams returns the names of the in: path parameters.  Returns false when a $ref parameter makes the list incomplete.
+func declaredPathParams(params interface{}, names map[string]bool) bool {
+	list, _ := params.([]interface{})
+
+	complete := true
+	for _, p := range list {
+		param, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if _, isRef := param["$ref"]; isRef {
+			complete = false
+			continue
+		}
+
+		if in, _ := param["in"].(string); in == "path" {
+			name, _ := param["name"].(string)
+			names[name] = true
+		}
+	}
+	return complete
+}
+
+// openAPISchema returns the compiled JSON Schema of the spec version
+func openAPISchema(version string) (*jsonschema.Schema, error) {
+	compiledMu.Lock()
+	defer compiledMu.Unlock()
+
+	if schema, found := compiledSchemas[version]; found {
+		return schema, nil
+	}
+
+	official := openAPISchemas[version]
+	compiler := jsonschema.NewCompiler()
+
+	// The patterns are ECMA-262 regular expressions, Go can not check them
+	compiler.Formats["regex"] = func(interface{}) bool { return true }
+
+	if err := compiler.AddResource("http://json-schema.org/draft-04/schema", strings.NewReader(draft04Schema)); err != nil {
+		return nil, err
+	}
+
+	if err := compiler.AddResource(official.URL, strings.NewReader(official.Schema)); err != nil {
+		return nil, err
+	}
+
+	schema, err := compiler.Compile(official.URL)
+	if err != nil {
+		return nil, fmt.Errorf("could not compile the OpenAPI %s schema: %v", version, err)
+	}
+	compiledSchemas[version] = schema
+	return schema, nil
+}
+
+// schemaProblems flattens the validation error to its leaf causes, ie /info/version: expected string, but got number
+func schemaProblems(ve *jsonschema.ValidationError) []string {
+	if len(ve.Causes) == 0 {
+		location := ve.InstanceLocation
+		if len(location) == 0 {
+			location = "/"
+		}
+		return []string{location + ": " + ve.Message}
+	}
+
+	problems := make([]string, 0)
+	for _, cause := range ve.Causes {
+		problems = append(problems, schemaProblems(cause)...)
+	}
+	return problems
+}
+
+// validateOpenAPI validates the spec against the official JSON Schema of its version, then checks the operationIds are unique and the
+// path parameters are declared, which the schema can not express.  The content is the spec as JSON.  Returns the list of problems found.
+func validateOpenAPI(doc map[string]interface{}, content []byte) []string {
+	problems := make([]string, 0)
+
+	version := ""
+	_, isSwagger := doc["swagger"]
+	openapi, isOpenAPI := doc["openapi"].(string)
+
+	switch {
+	case isSwagger:
+		version = "2.0"
+	case isOpenAPI && openAPIVersion.MatchString(openapi):
+		version = openapi[:3]
+	case isOpenAPI:
+		return append(problems, fmt.Sprintf("unsupported openapi version %q", openapi))
+	default:
+		return append(problems, "missing swagger or openapi version field")
+	}
+
+	schema, err := openAPISchema(version)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+
+	instance, err := decodeJSON(content)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+
+	if err := schema.Validate(instance); err != nil {
+		var ve *jsonschema.ValidationError
+		if !errors.As(err, &ve) {
+			return append(problems, err.Error())
+		}
+		problems = append(problems, schemaProblems(ve)...)
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	operationIDs := make(map[string]string, 0)
+	for path, p := range paths {
+		item, ok := p.(map[string]interface{})
+		if !ok || strings.HasPrefix(path, "x-") {
+			continue
+		}
+
+		if _, isRef := item["$ref"]; isRef {
+			continue
+		}
+
+		pathNames := make(map[string]bool, 0)
+		pathComplete := declaredPathParams(item["parameters"], pathNames)
+
+		for _, method := range apiMethods {
+			op, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			name := strings.ToUpper(method) + " " + path
+			if id, _ := op["operationId"].(string); len(id) > 0 {
+				if other, dup := operationIDs[id]; dup {
+					problems = append(problems, fmt.Sprintf("%s: operationId %s is already used by %s", name, id, other))
+				}
+				operationIDs[id] = name
+			}
+
+			names := make(map[string]bool, 0)
+			for n := range pathNames {
+				names[n] = true
+			}
+
+			if complete := declaredPathParams(op["parameters"], names); !complete || !pathComplete {
+				continue
+			}
+
+			for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+				if !names[m[1]] {
+					problems = append(problems, fmt.Sprintf("%s: path parameter %s is not declared", name, m[1]))
+				}
+			}
+		}
+	}
+
+	// A failing oneOf reports the same leaf for several branches
+	sort.Strings(problems)
+	unique := make([]string, 0, len(problems))
+	for i, problem := range problems {
+		if i == 0 || problem != problems[i-1] {
+			unique = append(unique, problem)
+		}
+	}
+	return unique
+}
+
+// apiAttrs returns the title, version and endpoints of the API as component version attributes
+func apiAttrs(spec *apiSpec) map[string]string {
+	attrs := map[string]string{
+		"API_SPEC_VERSION":  spec.SpecVersion,
+		"API_TITLE":         spec.Title,
+		"API_VERSION":       spec.Version,
+		"API_ENDPOINTS_CNT": fmt.Sprintf("%d", len(spec.Endpoints)),
+	}
+
+	if len(spec.Problems) > 0 {
+		attrs["API_SPEC_PROBLEMS_CNT"] = fmt.Sprintf("%d", len(spec.Problems))
+	}
+	return attrs
+}
+
+// apiProvides lists the endpoints the way the Providing object stores them, ie GET /pets/{id}
+func apiProvides(spec *apiSpec) []string {
+	provides := make([]string, 0, len(spec.Endpoints))
+	for _, e := range spec.Endpoints {
+		provides = append(provides, e.Method+" "+e.Path)
+	}
+	return provides
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseOpenAPI(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		version  string
+		problems []string // Substrings of the expected problems, in order
+	}{
+		{
+			name: "valid 3.0 yaml",
+			spec: `openapi: 3.0.3
+info: {title: pets, version: "1.0"}
+paths:
+  /pets/{id}:
+    get:
+      operationId: getPet
+      parameters: [{name: id, in: path, required: true, schema: {type: string}}]
+      responses: {"200": {description: ok}}
+`,
+			version: "1.0",
+		},
+		{
+			name: "numeric info.version keeps its text",
+			spec: `openapi: 3.0.3
+info: {title: pets, version: 1.0}
+paths: {}
+`,
+			version:  "1.0",
+			problems: []string{"/info/version"},
+		},
+		{
+			name:    "3.1 with only webhooks",
+			spec:    `{"openapi": "3.1.0", "info": {"title": "hooks", "version": "2"}, "webhooks": {}}`,
+			version: "2",
+		},
+		{
+			name:     "3.1 without paths, components or webhooks",
+			spec:     `{"openapi": "3.1.0", "info": {"title": "empty", "version": "2"}}`,
+			version:  "2",
+			problems: []string{"/"},
+		},
+		{
+			name: "swagger 2.0 has no trace operation",
+			spec: `swagger: "2.0"
+info: {title: pets, version: v1}
+paths:
+  /pets:
+    trace:
+      responses: {"200": {description: ok}}
+`,
+			version:  "v1",
+			problems: []string{"/paths/~1pets"},
+		},
+		{
+			name: "duplicate operationId and undeclared path parameter",
+			spec: `openapi: 3.0.3
+info: {title: pets, version: "1"}
+paths:
+  /pets/{id}:
+    get:
+      operationId: pet
+      responses: {"200": {description: ok}}
+    put:
+      operationId: pet
+      parameters: [{name: id, in: path, required: true, schema: {type: string}}]
+      responses: {"200": {description: ok}}
+`,
+			version:  "1",
+			problems: []string{"GET /pets/{id}: path parameter id is not declared", "operationId pet is already used by"},
+		},
+		{
+			name:     "unsupported version",
+			spec:     `{"openapi": "4.0.0", "info": {"title": "next", "version": "1"}, "paths": {}}`,
+			version:  "1",
+			problems: []string{`unsupported openapi version "4.0.0"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseOpenAPI([]byte(tt.spec))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if spec.Version != tt.version {
+				t.Errorf("Version = %q, want %q", spec.Version, tt.version)
+			}
+
+			if len(spec.Problems) < len(tt.problems) || (len(tt.problems) == 0 && len(spec.Problems) > 0) {
+				t.Fatalf("Problems = %q, want %q", spec.Problems, tt.problems)
+			}
+
+			for _, want := range tt.problems {
+				found := false
+				for _, problem := range spec.Problems {
+					if strings.Contains(problem, want) {
+						found = true
+					}
+				}
+
+				if !found {
+					t.Errorf("Problems = %q, want one containing %q", spec.Problems, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseOpenAPIFixture(t *testing.T) {
+	data, err := os.ReadFile("openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := parseOpenAPI(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(spec.Problems) > 0 {
+		t.Errorf("Problems = %q, want none", spec.Problems)
+	}
+
+	if spec.SpecVersion != "3.0.2" || spec.Title != "ortelius-ms-textfile-crud" {
+		t.Errorf("SpecVersion, Title = %q, %q", spec.SpecVersion, spec.Title)
+	}
+}
@@ -0,0 +1,231 @@
+// Package main - ServiceOwner fallback from CODEOWNERS and the git history, with a git email to Ortelius user mapping
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	model "github.com/ortelius/scec-commons/model"
+	toml "github.com/pelletier/go-toml"
+)
+
+// ownerMapping is an [[Owner]] entry of the owner map file.  Email or Handle identify the git author or CODEOWNERS owner.
+type ownerMapping struct {
+	Email          string `toml:"Email"`
+	Handle         string `toml:"Handle"` // CODEOWNERS owner, ie @jdoe or @org/payments
+	User           string `toml:"User"`   // Ortelius user, ie GLOBAL.jdoe
+	Realname       string `toml:"Realname"`
+	SlackChannel   string `toml:"SlackChannel"`
+	DiscordChannel string `toml:"DiscordChannel"`
+	HipchatChannel string `toml:"HipchatChannel"`
+	PagerdutyURL   string `toml:"PagerdutyURL"`
+}
+
+// committer is a git author and the number of commits in the period
+type committer struct {
+	Email   string
+	Name    string
+	Commits int
+}
+
+// readOwnerMap reads the [[Owner]] entries of the owner map toml
+func readOwnerMap(filename string) ([]ownerMapping, error) {
+	if len(filename) == 0 {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Owner []ownerMapping `toml:"Owner"`
+	}
+
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse the owner map %s: %v", filename, err)
+	}
+	return doc.Owner, nil
+}
+
+// lookupOwner finds the mapping for a git email, CODEOWNERS handle or Ortelius user name
+func lookupOwner(mappings []ownerMapping, id string) *ownerMapping {
+	for i, m := range mappings {
+		if (len(m.Email) > 0 && strings.EqualFold(m.Email, id)) ||
+			(len(m.Handle) > 0 && strings.EqualFold(m.Handle, id)) ||
+			(len(m.User) > 0 && strings.EqualFold(m.User, id)) {
+			return &mappings[i]
+		}
+	}
+	return nil
+}
+
+// codeOwnersMatches reports if the CODEOWNERS pattern covers the component directory, either the directory itself or one of its parents.
+// Patterns for files inside the component, ie *.go, do not make someone the owner of the component.
+func codeOwnersMatches(pattern string, dir string) bool {
+	switch pattern {
+	case "*", "**", "/*", "/**":
+		return true
+	}
+
+	if len(dir) == 0 {
+		return false
+	}
+
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.Trim(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/**")
+	if !anchored {
+		pattern = "**/" + pattern
+	}
+
+	parts := strings.Split(dir, "/")
+	for i := len(parts); i > 0; i-- {
+		if match, err := doublestar.Match(pattern, strings.Join(parts[:i], "/")); err == nil && match {
+			return true
+		}
+	}
+	return false
+}
+
+// codeOwnersFor returns the last rule that covers the component directory, the same precedence GitHub uses
+func codeOwnersFor(rules []codeOwnersRule, dir string) (codeOwnersRule, bool) {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if codeOwnersMatches(rules[i].Pattern, dir) {
+			return rules[i], true
+		}
+	}
+	return codeOwnersRule{}, false
+}
+
+// repoCodeOwners reads the CODEOWNERS rules from the top of the git repo, searching the places of the codeowners document type
+func repoCodeOwners() []codeOwnersRule {
+	top := runGit("git rev-parse --show-toplevel 2>/dev/null")
+	if len(top) == 0 {
+		return nil
+	}
+
+	for _, location := range matchFiles(repoFiles(top), documentTypes[CodeownersFile].Patterns) {
+		if data, err := os.ReadFile(filepath.Join(top, location)); err == nil {
+			return parseCodeOwners(strings.Split(string(data), "\n"))
+		}
+	}
+	return nil
+}
+
+// topCommitters returns the authors of the commits to the component directory in the last days, most commits first.  Bots are skipped.
+func topCommitters(days int) []committer {
+	history := runGit("git log --since='" + strconv.Itoa(days) + " days ago' --format='%aE|%aN' -- . 2>/dev/null")
+
+	counts := make(map[string]*committer, 0)
+	for _, line := range strings.Split(history, "\n") {
+		email, name, found := strings.Cut(strings.TrimSpace(line), "|")
+		if !found || len(email) == 0 || strings.Contains(email, "[bot]") || strings.Contains(strings.ToLower(name), "dependabot") {
+			continue
+		}
+
+		key := strings.ToLower(email)
+		if _, found := counts[key]; !found {
+			counts[key] = &committer{Email: email, Name: name}
+		}
+		counts[key].Commits++
+	}
+
+	committers := make([]committer, 0, len(counts))
+	for _, c := range counts {
+		committers = append(committers, *c)
+	}
+
+	sort.Slice(committers, func(i, j int) bool {
+		if committers[i].Commits != committers[j].Commits {
+			return committers[i].Commits > committers[j].Commits
+		}
+		return committers[i].Email < committers[j].Email
+	})
+	return committers
+}
+
+// ownerUser turns a CODEOWNERS owner or git email into a user, through the owner map when it has an entry
+func ownerUser(mappings []ownerMapping, id string, realname string) (*model.User, *ownerMapping) {
+	user := model.NewUser()
+	user.Realname = realname
+
+	mapping := lookupOwner(mappings, id)
+	switch {
+	case mapping != nil && len(mapping.User) > 0:
+		user.Name, user.Domain = makeName(mapping.User)
+		if len(mapping.Realname) > 0 {
+			user.Realname = mapping.Realname
+		}
+		user.Email = mapping.Email
+	case strings.Contains(id, "@") && !strings.HasPrefix(id, "@"):
+		// Unmapped email, the local part is the best guess for the user name
+		user.Email = id
+		user.Name, _, _ = strings.Cut(id, "@")
+	default:
+		user.Name = strings.TrimPrefix(id, "@")
+	}
+	return user, mapping
+}
+
+// resolveServiceOwner fills the ServiceOwner when component.toml has none, from the CODEOWNERS rule of the component directory and then from the top committers.
+// The source and the candidates are recorded and empty notification channels are filled from the owner map.
+func resolveServiceOwner(attrs *model.CompAttrs, tomlVars map[string]string, ownerMap string, days int) error {
+	mappings, err := readOwnerMap(ownerMap)
+	if err != nil {
+		return err
+	}
+
+	source := "none"
+	var mapping *ownerMapping
+
+	if len(attrs.ServiceOwner.Name) > 0 {
+		source = "component.toml"
+		mapping = lookupOwner(mappings, attrs.ServiceOwner.Name)
+		if mapping == nil && len(attrs.ServiceOwner.Domain.Name) > 0 {
+			mapping = lookupOwner(mappings, attrs.ServiceOwner.Domain.Name+"."+attrs.ServiceOwner.Name)
+		}
+	} else if rule, found := codeOwnersFor(repoCodeOwners(), strings.TrimSuffix(runGit("git rev-parse --show-prefix 2>/dev/null"), "/")); found && len(rule.Owners) > 0 {
+		source = "codeowners"
+		attrs.ServiceOwner, mapping = ownerUser(mappings, rule.Owners[0], "")
+		tomlVars["SERVICEOWNER_RULE"] = rule.Pattern
+		tomlVars["SERVICEOWNER_CANDIDATES"] = strings.Join(rule.Owners, ",")
+	} else if committers := topCommitters(days); len(committers) > 0 {
+		source = "git-history"
+		attrs.ServiceOwner, mapping = ownerUser(mappings, committers[0].Email, committers[0].Name)
+
+		candidates := make([]string, 0)
+		for i := 0; i < len(committers) && i < 3; i++ {
+			candidates = append(candidates, fmt.Sprintf("%s(%d)", committers[i].Email, committers[i].Commits))
+		}
+		tomlVars["SERVICEOWNER_CANDIDATES"] = strings.Join(candidates, ",")
+	}
+
+	tomlVars["SERVICEOWNER_SOURCE"] = source
+	if source != "none" {
+		slog.Info("service owner", "owner", attrs.ServiceOwner.Name, "source", source)
+	}
+
+	if mapping != nil {
+		if len(attrs.SlackChannel) == 0 {
+			attrs.SlackChannel = mapping.SlackChannel
+		}
+		if len(attrs.DiscordChannel) == 0 {
+			attrs.DiscordChannel = mapping.DiscordChannel
+		}
+		if len(attrs.HipchatChannel) == 0 {
+			attrs.HipchatChannel = mapping.HipchatChannel
+		}
+		if len(attrs.PagerdutyURL) == 0 {
+			attrs.PagerdutyURL = mapping.PagerdutyURL
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+//go:build cgo
+
+// Package main - PKCS#11 token keys for the DSSE signing, the module is loaded with cgo
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+)
+
+// pkcs11Key is a crypto.Signer for a ECDSA or RSA private key in a PKCS#11 token
+type pkcs11Key struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	handle  pkcs11.ObjectHandle
+	pub     crypto.PublicKey
+}
+
+// Public returns the public key read from the token
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// sha256DigestInfo is the DER DigestInfo prefix CKM_RSA_PKCS expects in front of a sha256 digest
+var sha256DigestInfo = []byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20}
+
+// Sign signs the sha256 digest in the token, ECDSA signatures are converted from r||s to ASN.1
+func (k *pkcs11Key) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	switch k.pub.(type) {
+	case *rsa.PublicKey:
+		if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}, k.handle); err != nil {
+			return nil, err
+		}
+		return k.ctx.Sign(k.session, append(append([]byte{}, sha256DigestInfo...), digest...))
+	case *ecdsa.PublicKey:
+		if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, k.handle); err != nil {
+			return nil, err
+		}
+
+		sig, err := k.ctx.Sign(k.session, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:])})
+	}
+	return nil, fmt.Errorf("unsupported PKCS#11 key type %T", k.pub)
+}
+
+// pkcs11Curves maps the DER encoded named curve OIDs in CKA_EC_PARAMS to the curves
+var pkcs11Curves = map[string]elliptic.Curve{
+	"06082a8648ce3d030107": elliptic.P256(),
+	"06052b81040022":       elliptic.P384(),
+	"06052b81040023":       elliptic.P521(),
+}
+
+// findPKCS11Object returns the first object of the class with the label
+func findPKCS11Object(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if len(label) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+	}
+
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, err
+	}
+	defer ctx.FindObjectsFinal(session)
+
+	handles, _, err := ctx.FindObjects(session, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(handles) == 0 {
+		return 0, fmt.Errorf("no PKCS#11 key labelled %q", label)
+	}
+	return handles[0], nil
+}
+
+// loadPKCS11Key logs into the token and returns the private key with the label.  The public key object with the same label supplies the key id.
+// The key holds the session until Close, the module is released on every error.
+func loadPKCS11Key(module string, token string, label string, pin string) (*pkcs11Key, error) {
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, fmt.Errorf("could not load PKCS#11 module %s", module)
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, err
+	}
+
+	key, err := openPKCS11Key(ctx, token, label, pin)
+	if err == nil && key == nil {
+		err = fmt.Errorf("no PKCS#11 token %q found in %s", token, module)
+	}
+
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	return key, nil
+}
+
+// openPKCS11Key opens a session on the token and logs in, the session is closed again when the key can not be read.  Nil when there is no such token.
+func openPKCS11Key(ctx *pkcs11.Ctx, token string, label string, pin string) (*pkcs11Key, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || (len(token) > 0 && strings.TrimSpace(info.Label) != token) {
+			continue
+		}
+
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+			ctx.CloseSession(session)
+			return nil, err
+		}
+
+		key, err := readPKCS11Key(ctx, session, label)
+		if err != nil {
+			ctx.Logout(session)
+			ctx.CloseSession(session)
+			return nil, err
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// readPKCS11Key returns the private key with the label and its public key from the logged in session
+func readPKCS11Key(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (*pkcs11Key, error) {
+	handle, err := findPKCS11Object(ctx, session, pkcs11.CKO_PRIVATE_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+
+	pubHandle, err := findPKCS11Object(ctx, session, pkcs11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := ctx.GetAttributeValue(session, pubHandle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keyType, err := attributeUlong(attrs[0].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &pkcs11Key{ctx: ctx, session: session, handle: handle}
+
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		attrs, err := ctx.GetAttributeValue(session, pubHandle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		key.pub = &rsa.PublicKey{N: new(big.Int).SetBytes(attrs[0].Value), E: int(new(big.Int).SetBytes(attrs[1].Value).Int64())}
+	case pkcs11.CKK_EC:
+		attrs, err := ctx.GetAttributeValue(session, pubHandle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		curve, found := pkcs11Curves[fmt.Sprintf("%x", attrs[0].Value)]
+		if !found {
+			return nil, fmt.Errorf("unsupported PKCS#11 curve %x", attrs[0].Value)
+		}
+
+		// CKA_EC_POINT is the uncompressed point wrapped in a DER OCTET STRING
+		var point []byte
+		if _, err := asn1.Unmarshal(attrs[1].Value, &point); err != nil {
+			point = attrs[1].Value
+		}
+
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, fmt.Errorf("invalid PKCS#11 EC point for %q", label)
+		}
+		key.pub = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key type for %q, only ECDSA and RSA keys are supported", label)
+	}
+	return key, nil
+}
+
+// Close logs out of the token and releases the session and the module
+func (k *pkcs11Key) Close() {
+	k.ctx.Logout(k.session)
+	k.ctx.CloseSession(k.session)
+	k.ctx.Finalize()
+	k.ctx.Destroy()
+}
+
+// attributeUlong decodes a CK_ULONG attribute value, the module returns it in the native byte order and size of the platform
+func attributeUlong(value []byte) (uint64, error) {
+	switch len(value) {
+	case 8:
+		return binary.NativeEndian.Uint64(value), nil
+	case 4:
+		return uint64(binary.NativeEndian.Uint32(value)), nil
+	}
+	return 0, fmt.Errorf("invalid CK_ULONG attribute length %d", len(value))
+}
@@ -0,0 +1,22 @@
+//go:build !cgo
+
+// Package main - PKCS#11 stub for builds without cgo, the key file signing still works
+package main
+
+import (
+	"crypto"
+	"fmt"
+)
+
+// pkcs11Key is never created without cgo
+type pkcs11Key struct {
+	crypto.Signer
+}
+
+// loadPKCS11Key fails, loading a PKCS#11 module needs cgo
+func loadPKCS11Key(module string, token string, label string, pin string) (*pkcs11Key, error) {
+	return nil, fmt.Errorf("PKCS#11 not supported in this build")
+}
+
+// Close does nothing
+func (k *pkcs11Key) Close() {}
@@ -0,0 +1,162 @@
+// Package main - Linking a new component version to the latest version of the same component and variant
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	resty "github.com/go-resty/resty/v2"
+	model "github.com/ortelius/scec-commons/model"
+)
+
+var (
+	shortStatAdded   = regexp.MustCompile(`(\d+) insertions?\(\+\)`) // shortStatAdded matches the lines added in git diff --shortstat
+	shortStatDeleted = regexp.MustCompile(`(\d+) deletions?\(-\)`)   // shortStatDeleted matches the lines deleted in git diff --shortstat
+)
+
+// gitLineDelta counts the lines added and deleted from the previous component commit to the current one.  Returns 0 when the previous commit is not in the local history.
+func gitLineDelta(from string, to string) (string, string) {
+	if len(from) == 0 || len(to) == 0 {
+		return "0", "0"
+	}
+
+	if len(runGit("git cat-file -e "+from+"^{commit} 2>/dev/null && echo found")) == 0 {
+		slog.Warn("the previous component commit is not in the local git history", "commit", from)
+		return "0", "0"
+	}
+
+	stat := runGit("git diff --shortstat " + from + " " + to + " 2>/dev/null")
+
+	added, deleted := "0", "0"
+	if m := shortStatAdded.FindStringSubmatch(stat); m != nil {
+		added = m[1]
+	}
+	if m := shortStatDeleted.FindStringSubmatch(stat); m != nil {
+		deleted = m[1]
+	}
+	return added, deleted
+}
+
+// lookupLatestVersion returns the newest version under the parent, the same component and variant.  Nil when there is none, the server can not be reached
+// or it answers with a version of another component.
+func lookupLatestVersion(client *resty.Client, parentName string, parentKey string) *model.ComponentVersionDetails {
+	latest := model.NewComponentVersionDetails()
+	resp, err := client.R().
+		SetQueryParams(map[string]string{"parent": parentName, "latest": "true"}).
+		SetResult(latest).
+		Get("http://localhost:8080/msapi/compver")
+
+	if err != nil {
+		slog.Warn("could not look up the latest version", "parent", parentName, "error", err)
+		return nil
+	}
+
+	if !resp.IsSuccess() || len(latest.Key) == 0 {
+		return nil
+	}
+
+	if !versionOf(latest, parentName, parentKey) {
+		slog.Warn("ignoring the latest version, it belongs to another component", "parent", parentName, "compver", latest.Name, "key", latest.Key)
+		return nil
+	}
+	return latest
+}
+
+// lookupCommitVersion returns a version under the parent that was built from the commit, any recorded version and not only the latest.
+// Nil when there is none or the server can not be reached.
+func lookupCommitVersion(client *resty.Client, parentName string, parentKey string, commit string) *model.ComponentVersionDetails {
+	if len(commit) == 0 {
+		return nil
+	}
+
+	versions := make([]*model.ComponentVersionDetails, 0)
+	resp, err := client.R().
+		SetQueryParams(map[string]string{"parent": parentName, "gitcommit": commit}).
+		SetResult(&versions).
+		Get("http://localhost:8080/msapi/compver")
+
+	if err != nil {
+		slog.Warn("could not look up the versions of the commit", "parent", parentName, "commit", commit, "error", err)
+		return nil
+	}
+
+	if !resp.IsSuccess() {
+		return nil
+	}
+
+	for _, v := range versions {
+		if v != nil && v.Attrs != nil && sameCommit(v.Attrs.GitCommit, commit) && versionOf(v, parentName, parentKey) {
+			return v
+		}
+	}
+	return nil
+}
+
+// versionOf reports if the component version is a version of the parent: it hangs off the parent key or, when the parent is not on the server yet, its name is the parent name followed by ;version
+func versionOf(compver *model.ComponentVersionDetails, parentName string, parentKey string) bool {
+	if len(parentKey) > 0 {
+		return compver.ParentKey == parentKey
+	}
+
+	domain := ""
+	if compver.Domain != nil {
+		domain = compver.Domain.Name
+	}
+
+	version, found := strings.CutPrefix(qualifiedName(domain, compver.Name), parentName+";")
+	return found && len(version) > 0 && !strings.Contains(version, ";")
+}
+
+// linkPredecessor makes the latest version of the component and variant the predecessor of the new version and takes the previous component commit from it for the line deltas.
+// The ParentKey stays the variant or component the version hangs off.
+// A version that already exists, or any recorded version built from the same commit, is a duplicate and is refused unless forced.
+func linkPredecessor(client *resty.Client, compver *model.ComponentVersionDetails, parentName string, force bool) error {
+	fullName := qualifiedName(compver.Domain.Name, compver.Name)
+	existingKey := lookupComponentKey(client, fullName)
+	latest := lookupLatestVersion(client, parentName, compver.ParentKey)
+
+	if len(existingKey) > 0 {
+		if !force {
+			return fmt.Errorf("component version %s already exists as %s, use --force to record it again", fullName, existingKey)
+		}
+		slog.Warn("the component version already exists, recording it again", "compver", fullName, "key", existingKey)
+	}
+
+	// Rebuilding an older commit is a duplicate too, the latest version still counts when the server can not search by commit
+	recorded := lookupCommitVersion(client, parentName, compver.ParentKey, compver.Attrs.GitCommit)
+	if recorded == nil && latest != nil && latest.Attrs != nil && sameCommit(latest.Attrs.GitCommit, compver.Attrs.GitCommit) {
+		recorded = latest
+	}
+
+	if recorded != nil && recorded.Key != existingKey {
+		if !force {
+			return fmt.Errorf("commit %s is already recorded as %s, use --force to create %s anyway", compver.Attrs.GitCommit, recorded.Name, fullName)
+		}
+		slog.Warn("the commit is already recorded", "commit", compver.Attrs.GitCommit, "compver", recorded.Name)
+	}
+
+	if latest == nil {
+		slog.Info("no previous version found", "parent", parentName)
+		return nil
+	}
+
+	if latest.Key == existingKey {
+		// Recording the latest version again, it keeps its own predecessor
+		compver.PredecessorKey = latest.PredecessorKey
+		return nil
+	}
+
+	compver.PredecessorKey = latest.Key
+	slog.Info("previous version", "compver", latest.Name, "key", latest.Key)
+
+	if len(compver.Attrs.GitPrevCompCommit) == 0 && latest.Attrs != nil {
+		compver.Attrs.GitPrevCompCommit = latest.Attrs.GitCommit
+	}
+
+	if len(compver.Attrs.GitPrevCompCommit) > 0 {
+		compver.Attrs.GitLinesAdded, compver.Attrs.GitLinesDeleted = gitLineDelta(compver.Attrs.GitPrevCompCommit, compver.Attrs.GitCommit)
+	}
+	return nil
+}
@@ -0,0 +1,518 @@
+// Package main - SLSA provenance parsing and verification against the git repository
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	slsa1 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v1"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+// generatedBuildType identifies provenance generated by the CLI from the git repository and CI environment
+const generatedBuildType = "https://github.com/ortelius/scec-cli/buildtypes/ci/v1"
+
+// provenanceMaterial is a source or dependency the build consumed, materials in v0.2 and resolvedDependencies in v1
+type provenanceMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// provenanceFacts are the build facts extracted from a SLSA v0.2 or v1 predicate
+type provenanceFacts struct {
+	SLSAVersion  string
+	BuilderID    string
+	BuildType    string
+	InvocationID string
+	Parameters   json.RawMessage
+	Materials    []provenanceMaterial
+	StartedOn    string
+	FinishedOn   string
+	SourceURI    string
+	SourceCommit string
+	Level        int
+}
+
+// buildkitVCS is the version control information buildkit adds to the provenance metadata
+type buildkitVCS struct {
+	Source   string `json:"source"`
+	Revision string `json:"revision"`
+}
+
+// slsaV02Predicate is the https://slsa.dev/provenance/v0.2 predicate
+type slsaV02Predicate struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation struct {
+		ConfigSource provenanceMaterial `json:"configSource"`
+		Parameters   json.RawMessage    `json:"parameters"`
+	} `json:"invocation"`
+	Metadata struct {
+		BuildInvocationID string `json:"buildInvocationID"`
+		BuildStartedOn    string `json:"buildStartedOn"`
+		BuildFinishedOn   string `json:"buildFinishedOn"`
+		Buildkit          struct {
+			VCS buildkitVCS `json:"vcs"`
+		} `json:"https://mobyproject.org/buildkit@v1#metadata"`
+	} `json:"metadata"`
+	Materials []provenanceMaterial `json:"materials"`
+}
+
+// slsaV1Predicate is the https://slsa.dev/provenance/v1 predicate
+type slsaV1Predicate struct {
+	BuildDefinition struct {
+		BuildType          string `json:"buildType"`
+		ExternalParameters struct {
+			ConfigSource provenanceMaterial `json:"configSource"`
+			Workflow     struct {
+				Repository string `json:"repository"`
+			} `json:"workflow"`
+		} `json:"externalParameters"`
+		ResolvedDependencies []provenanceMaterial `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID     string `json:"invocationID"`
+			StartedOn        string `json:"startedOn"`
+			FinishedOn       string `json:"finishedOn"`
+			BuildkitMetadata struct {
+				VCS buildkitVCS `json:"vcs"`
+			} `json:"buildkit_metadata"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// materialCommit returns the git commit recorded in a material digest
+func materialCommit(m provenanceMaterial) string {
+	for _, alg := range []string{"gitCommit", "sha1"} {
+		if commit, found := m.Digest[alg]; found {
+			return commit
+		}
+	}
+	return ""
+}
+
+// isGitMaterial reports whether a material is the source repository rather than a base image or package.
+// It has a git scheme, a gitCommit digest or a .git repository path, .github or .gitignore files and gitlab hosts are not repositories.
+func isGitMaterial(m provenanceMaterial) bool {
+	uri := strings.ToLower(m.URI)
+	if strings.HasPrefix(uri, "git+") || strings.HasPrefix(uri, "git@") || strings.HasPrefix(uri, "git://") || len(m.Digest["gitCommit"]) > 0 {
+		return true
+	}
+
+	// The path of the URI without the host, the query and fragment or an in-toto @ref
+	_, rest, found := strings.Cut(uri, "://")
+	if !found {
+		return false
+	}
+	_, repoPath, _ := strings.Cut(rest, "/")
+	repoPath, _, _ = strings.Cut(repoPath, "?")
+	repoPath, _, _ = strings.Cut(repoPath, "#")
+	repoPath, _, _ = strings.Cut(repoPath, "@")
+
+	for _, segment := range strings.Split(repoPath, "/") {
+		if strings.HasSuffix(segment, ".git") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProvenance extracts the build facts from the {"SLSA": predicate} json returned by the image inspection
+func parseProvenance(data string) (*provenanceFacts, error) {
+	var wrapper struct {
+		SLSA json.RawMessage `json:"SLSA"`
+	}
+
+	if err := json.Unmarshal([]byte(data), &wrapper); err != nil {
+		return nil, fmt.Errorf("could not parse provenance: %v", err)
+	}
+
+	predicate := wrapper.SLSA
+	if len(predicate) == 0 {
+		predicate = json.RawMessage(data)
+	}
+
+	// A full in-toto statement, ie the generated provenance
+	if inner := probeField(predicate, "predicate"); len(inner) > 0 {
+		predicate = inner
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(predicate, &probe); err != nil {
+		return nil, fmt.Errorf("could not parse provenance: %v", err)
+	}
+
+	facts := new(provenanceFacts)
+	sources := make([]provenanceMaterial, 0)
+
+	if _, found := probe["buildDefinition"]; found {
+		var v1 slsaV1Predicate
+		if err := json.Unmarshal(predicate, &v1); err != nil {
+			return nil, fmt.Errorf("could not parse SLSA v1 provenance: %v", err)
+		}
+
+		facts.SLSAVersion = "v1"
+		facts.BuilderID = v1.RunDetails.Builder.ID
+		facts.BuildType = v1.BuildDefinition.BuildType
+		facts.InvocationID = v1.RunDetails.Metadata.InvocationID
+		facts.Parameters = probeField(predicate, "buildDefinition", "externalParameters")
+		facts.Materials = v1.BuildDefinition.ResolvedDependencies
+		facts.StartedOn = v1.RunDetails.Metadata.StartedOn
+		facts.FinishedOn = v1.RunDetails.Metadata.FinishedOn
+
+		vcs := v1.RunDetails.Metadata.BuildkitMetadata.VCS
+		sources = append(sources, provenanceMaterial{URI: vcs.Source, Digest: map[string]string{"gitCommit": vcs.Revision}})
+		sources = append(sources, v1.BuildDefinition.ExternalParameters.ConfigSource)
+		sources = append(sources, provenanceMaterial{URI: v1.BuildDefinition.ExternalParameters.Workflow.Repository})
+	} else {
+		var v02 slsaV02Predicate
+		if err := json.Unmarshal(predicate, &v02); err != nil {
+			return nil, fmt.Errorf("could not parse SLSA v0.2 provenance: %v", err)
+		}
+
+		facts.SLSAVersion = "v0.2"
+		facts.BuilderID = v02.Builder.ID
+		facts.BuildType = v02.BuildType
+		facts.InvocationID = v02.Metadata.BuildInvocationID
+		facts.Parameters = v02.Invocation.Parameters
+		facts.Materials = v02.Materials
+		facts.StartedOn = v02.Metadata.BuildStartedOn
+		facts.FinishedOn = v02.Metadata.BuildFinishedOn
+
+		vcs := v02.Metadata.Buildkit.VCS
+		sources = append(sources, provenanceMaterial{URI: vcs.Source, Digest: map[string]string{"gitCommit": vcs.Revision}})
+		sources = append(sources, v02.Invocation.ConfigSource)
+	}
+
+	// The explicit source wins over the git materials, the commit may come from a later candidate with the same repo
+	for _, m := range facts.Materials {
+		if isGitMaterial(m) {
+			sources = append(sources, m)
+		}
+	}
+
+	for _, m := range sources {
+		if len(m.URI) == 0 {
+			continue
+		}
+
+		if len(facts.SourceURI) == 0 {
+			facts.SourceURI = m.URI
+		}
+
+		if len(facts.SourceCommit) == 0 && sameRepo(facts.SourceURI, m.URI) {
+			facts.SourceCommit = materialCommit(m)
+		}
+	}
+
+	// Provenance naming its builder is SLSA Build L1, verifiedSLSALevel raises it to L2 once the signature is verified
+	if len(facts.BuilderID) > 0 {
+		facts.Level = 1
+	}
+	return facts, nil
+}
+
+// probeField returns the raw json of a nested object field
+func probeField(data json.RawMessage, keys ...string) json.RawMessage {
+	for _, key := range keys {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil
+		}
+		data = obj[key]
+	}
+	return data
+}
+
+// normalizeRepoURL reduces the https, ssh and scp style git urls to host/path so they can be compared
+func normalizeRepoURL(uri string) string {
+	uri = strings.TrimSpace(uri)
+	uri = strings.TrimPrefix(uri, "git+")
+
+	// Drop the ref or commit buildkit appends as a fragment and the pkg:github style @ref
+	uri, _, _ = strings.Cut(uri, "#")
+
+	if !strings.Contains(uri, "://") {
+		// scp style git@github.com:org/repo.git
+		if at := strings.Index(uri, "@"); at >= 0 {
+			uri = uri[at+1:]
+		}
+		uri = strings.Replace(uri, ":", "/", 1)
+	} else if u, err := url.Parse(uri); err == nil {
+		uri = u.Host + u.Path
+	}
+
+	if at := strings.Index(uri, "@"); at >= 0 {
+		uri = uri[:at]
+	}
+
+	uri = strings.TrimSuffix(strings.TrimSuffix(uri, "/"), ".git")
+	return strings.ToLower(uri)
+}
+
+// sameRepo compares two git urls ignoring the protocol, credentials and .git suffix
+func sameRepo(a string, b string) bool {
+	return normalizeRepoURL(a) == normalizeRepoURL(b)
+}
+
+// sameCommit compares commits allowing one of them to be the abbreviated sha
+func sameCommit(a string, b string) bool {
+	a = strings.ToLower(strings.TrimSpace(a))
+	b = strings.ToLower(strings.TrimSpace(b))
+
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// verifyProvenance checks the provenance source repository and commit against the git repository the CLI runs in.  Provenance without a source repository can not be tied to the repository and fails.
+func verifyProvenance(facts *provenanceFacts, gitURL string, gitCommit string) error {
+	if len(facts.SourceURI) == 0 {
+		return fmt.Errorf("the provenance does not record the source repository")
+	}
+
+	if len(gitURL) > 0 && !sameRepo(facts.SourceURI, gitURL) {
+		return fmt.Errorf("provenance source %s does not match GIT_URL %s", facts.SourceURI, gitURL)
+	}
+
+	if len(facts.SourceCommit) > 0 && len(gitCommit) > 0 && !sameCommit(facts.SourceCommit, gitCommit) {
+		return fmt.Errorf("provenance source commit %s does not match GIT_COMMIT %s", facts.SourceCommit, gitCommit)
+	}
+	return nil
+}
+
+// verifiedSLSALevel raises a SLSA Build L1 level to L2 when the image carries a SLSA provenance attestation signed by a trusted cosign key.
+// The attestations are the predicate types of the verified attestations, unverified ones never reach here.
+func verifiedSLSALevel(level string, attestations []string) string {
+	for _, predicate := range attestations {
+		if strings.HasPrefix(predicate, slsaPredicatePrefix) && level == "1" {
+			return "2"
+		}
+	}
+	return level
+}
+
+// provenanceAttrs returns the build facts as component version attributes
+func provenanceAttrs(facts *provenanceFacts) map[string]string {
+	attrs := map[string]string{
+		"SLSA_VERSION":       facts.SLSAVersion,
+		"SLSA_LEVEL":         fmt.Sprintf("%d", facts.Level),
+		"SLSA_BUILDER_ID":    facts.BuilderID,
+		"SLSA_BUILD_TYPE":    facts.BuildType,
+		"SLSA_INVOCATION_ID": facts.InvocationID,
+		"SLSA_STARTED_ON":    facts.StartedOn,
+		"SLSA_FINISHED_ON":   facts.FinishedOn,
+		"SLSA_SOURCE_URI":    facts.SourceURI,
+		"SLSA_SOURCE_COMMIT": facts.SourceCommit,
+		"SLSA_MATERIALS_CNT": fmt.Sprintf("%d", len(facts.Materials)),
+	}
+
+	if len(facts.Parameters) > 0 && string(facts.Parameters) != "null" {
+		attrs["SLSA_PARAMETERS"] = string(facts.Parameters)
+	}
+
+	for k, v := range attrs {
+		if len(v) == 0 {
+			delete(attrs, k)
+		}
+	}
+	return attrs
+}
+
+// ciEnvironment is the builder and invocation of the CI system running the CLI
+type ciEnvironment struct {
+	BuilderID    string
+	InvocationID string
+	Parameters   map[string]string
+}
+
+// envParameters returns the non empty environment variables
+func envParameters(names ...string) map[string]string {
+	params := make(map[string]string, 0)
+
+	for _, name := range names {
+		if v := os.Getenv(name); len(v) > 0 {
+			params[name] = v
+		}
+	}
+	return params
+}
+
+// detectCI derives the builder id and invocation from the well known CI environment variables
+func detectCI() ciEnvironment {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		runner := "self-hosted"
+		if os.Getenv("RUNNER_ENVIRONMENT") == "github-hosted" {
+			runner = "github-hosted"
+		}
+
+		return ciEnvironment{
+			BuilderID:    "https://github.com/actions/runner/" + runner,
+			InvocationID: fmt.Sprintf("%s/%s/actions/runs/%s/attempts/%s", os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"), os.Getenv("GITHUB_RUN_ATTEMPT")),
+			Parameters:   envParameters("GITHUB_WORKFLOW_REF", "GITHUB_EVENT_NAME", "GITHUB_REF", "GITHUB_REPOSITORY", "GITHUB_ACTOR"),
+		}
+	case os.Getenv("GITLAB_CI") == "true":
+		return ciEnvironment{
+			BuilderID:    fmt.Sprintf("%s/%s/-/runners/%s", os.Getenv("CI_SERVER_URL"), os.Getenv("CI_PROJECT_PATH"), os.Getenv("CI_RUNNER_ID")),
+			InvocationID: os.Getenv("CI_JOB_URL"),
+			Parameters:   envParameters("CI_CONFIG_PATH", "CI_PIPELINE_SOURCE", "CI_COMMIT_REF_NAME", "CI_PROJECT_PATH", "CI_JOB_NAME"),
+		}
+	case len(os.Getenv("JENKINS_URL")) > 0:
+		return ciEnvironment{
+			BuilderID:    os.Getenv("JENKINS_URL"),
+			InvocationID: os.Getenv("BUILD_URL"),
+			Parameters:   envParameters("JOB_NAME", "BUILD_NUMBER", "GIT_BRANCH", "NODE_NAME"),
+		}
+	case os.Getenv("CIRCLECI") == "true":
+		return ciEnvironment{
+			BuilderID:    "https://circleci.com/" + os.Getenv("CIRCLE_PROJECT_USERNAME") + "/" + os.Getenv("CIRCLE_PROJECT_REPONAME"),
+			InvocationID: os.Getenv("CIRCLE_BUILD_URL"),
+			Parameters:   envParameters("CIRCLE_JOB", "CIRCLE_BRANCH", "CIRCLE_WORKFLOW_ID"),
+		}
+	}
+
+	// Not running in CI, the builder is the machine the CLI runs on
+	hostname, _ := os.Hostname()
+	return ciEnvironment{BuilderID: "https://github.com/ortelius/scec-cli/local/" + hostname, Parameters: make(map[string]string, 0)}
+}
+
+// hashFile returns the sha256 digest of a file
+func hashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// provenanceSubjects returns the image digests and the sha256 of the artifact files matching the glob patterns
+func provenanceSubjects(repo string, dockerSha string, platforms []platformAttestations, patterns []string) ([]in_toto.Subject, error) {
+	subjects := make([]in_toto.Subject, 0)
+
+	if len(dockerSha) > 0 {
+		subjects = append(subjects, in_toto.Subject{Name: repo, Digest: common.DigestSet{"sha256": dockerSha}})
+	}
+
+	for _, platform := range platforms {
+		if digest := strings.TrimPrefix(platform.Digest, "sha256:"); len(digest) > 0 && digest != dockerSha {
+			subjects = append(subjects, in_toto.Subject{Name: repo, Digest: common.DigestSet{"sha256": digest}})
+		}
+	}
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, filename := range matches {
+			if info, err := os.Stat(filename); err != nil || info.IsDir() {
+				continue
+			}
+
+			digest, err := hashFile(filename)
+			if err != nil {
+				return nil, err
+			}
+			subjects = append(subjects, in_toto.Subject{Name: filepath.ToSlash(filename), Digest: common.DigestSet{"sha256": digest}})
+		}
+	}
+
+	if len(subjects) == 0 {
+		return nil, fmt.Errorf("no image digest or artifact files to use as the provenance subject")
+	}
+	return subjects, nil
+}
+
+// generateProvenance creates an in-toto statement with a SLSA v1 predicate for a build that did not produce provenance
+func generateProvenance(subjects []in_toto.Subject, gitURL string, gitCommit string, gitBranch string, startedOn time.Time) ([]byte, error) {
+	ci := detectCI()
+	finishedOn := time.Now().UTC()
+	startedOn = startedOn.UTC()
+
+	external := map[string]interface{}{
+		"source": map[string]string{
+			"uri": gitURL,
+			"ref": gitBranch,
+		},
+	}
+	if len(ci.Parameters) > 0 {
+		external["ci"] = ci.Parameters
+	}
+
+	// git+https is the SPDX download location style, scp style ssh urls are kept as is
+	sourceURI := gitURL
+	if strings.Contains(gitURL, "://") {
+		sourceURI = "git+" + gitURL
+	}
+
+	statement := in_toto.ProvenanceStatementSLSA1{
+		StatementHeader: in_toto.StatementHeader{
+			Type:          in_toto.StatementInTotoV01,
+			PredicateType: slsa1.PredicateSLSAProvenance,
+			Subject:       subjects,
+		},
+		Predicate: slsa1.ProvenancePredicate{
+			BuildDefinition: slsa1.ProvenanceBuildDefinition{
+				BuildType:          generatedBuildType,
+				ExternalParameters: external,
+				ResolvedDependencies: []slsa1.ResourceDescriptor{
+					{URI: sourceURI, Digest: common.DigestSet{"gitCommit": gitCommit}},
+				},
+			},
+			RunDetails: slsa1.ProvenanceRunDetails{
+				Builder: slsa1.Builder{ID: ci.BuilderID},
+				BuildMetadata: slsa1.BuildMetadata{
+					InvocationID: ci.InvocationID,
+					StartedOn:    &startedOn,
+					FinishedOn:   &finishedOn,
+				},
+			},
+		},
+	}
+	return json.Marshal(statement)
+}
+
+// wrapDSSE wraps the statement in a DSSE envelope, signed when a signer is configured
+func wrapDSSE(statement []byte, signer *dsse.EnvelopeSigner) ([]byte, error) {
+	if signer != nil {
+		envelope, err := signer.SignPayload(context.Background(), inTotoMediaType, statement)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(envelope)
+	}
+
+	envelope := dsse.Envelope{
+		PayloadType: inTotoMediaType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures:  []dsse.Signature{},
+	}
+	return json.Marshal(envelope)
+}
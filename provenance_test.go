@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestIsGitMaterial(t *testing.T) {
+	tests := []struct {
+		name string
+		m    provenanceMaterial
+		want bool
+	}{
+		{"in-toto git scheme", provenanceMaterial{URI: "git+https://github.com/org/repo@refs/heads/main"}, true},
+		{"scp style remote", provenanceMaterial{URI: "git@github.com:org/repo.git"}, true},
+		{"repository path", provenanceMaterial{URI: "https://github.com/org/repo.git"}, true},
+		{"repository path with a ref", provenanceMaterial{URI: "https://gitlab.example.com/org/repo.git@v1.0.0"}, true},
+		{"gitCommit digest", provenanceMaterial{URI: "https://example.com/org/repo", Digest: map[string]string{"gitCommit": "abc123"}}, true},
+		{"github workflow file", provenanceMaterial{URI: "https://github.com/org/repo/.github/workflows/build.yml"}, false},
+		{"gitignore file", provenanceMaterial{URI: "https://example.com/org/repo/.gitignore"}, false},
+		{"gitlab host", provenanceMaterial{URI: "https://x.gitlab.example.com/org/image"}, false},
+		{"base image", provenanceMaterial{URI: "pkg:docker/alpine@3.19", Digest: map[string]string{"sha256": "abc"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isGitMaterial(tt.m); got != tt.want {
+				t.Errorf("isGitMaterial(%q) = %v, want %v", tt.m.URI, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyProvenance(t *testing.T) {
+	tests := []struct {
+		name    string
+		facts   provenanceFacts
+		wantErr bool
+	}{
+		{"matching source", provenanceFacts{SourceURI: "git+https://github.com/org/repo", SourceCommit: "abc1234def"}, false},
+		{"abbreviated commit", provenanceFacts{SourceURI: "https://github.com/org/repo.git", SourceCommit: "abc1234"}, false},
+		{"missing source", provenanceFacts{SourceCommit: "abc1234def"}, true},
+		{"other repository", provenanceFacts{SourceURI: "https://github.com/org/other", SourceCommit: "abc1234def"}, true},
+		{"other commit", provenanceFacts{SourceURI: "https://github.com/org/repo", SourceCommit: "fff0000"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyProvenance(&tt.facts, "https://github.com/org/repo.git", "abc1234def")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyProvenance() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
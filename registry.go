@@ -0,0 +1,362 @@
+// Package main - Registry authentication and offline image sources for the image inspection
+package main
+
+import (
+	"archive/tar"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/buildx/util/imagetools"
+	"github.com/docker/buildx/util/resolver"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	clitypes "github.com/docker/cli/cli/config/types"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+)
+
+const (
+	inTotoMediaType           = "application/vnd.in-toto+json" // inTotoMediaType is the layer media type of a plain in-toto statement
+	inTotoDSSEMediaTypePrefix = "application/vnd.in-toto."     // inTotoDSSEMediaTypePrefix is the prefix of the DSSE wrapped statement media types
+	predicateTypeAnnotation   = "in-toto.io/predicate-type"    // predicateTypeAnnotation holds the predicate type of an attestation layer
+	referenceTypeAnnotation   = "vnd.docker.reference.type"    // referenceTypeAnnotation marks attestation manifests in an index
+	referenceDigestAnnotation = "vnd.docker.reference.digest"  // referenceDigestAnnotation is the platform manifest an attestation manifest describes
+	spdxPredicateType         = "https://spdx.dev/Document"    // spdxPredicateType is the predicate type of a SPDX SBOM attestation
+	slsaPredicatePrefix       = "https://slsa.dev/provenance/" // slsaPredicatePrefix is the predicate type prefix of SLSA provenance
+	dockerHubAuthHost         = "https://index.docker.io/v1/"  // dockerHubAuthHost is the key docker uses for Docker Hub credentials
+	attestationManifest       = "attestation-manifest"         // attestationManifest is the reference type of buildkit attestation manifests
+	ociLayoutPrefix           = "oci:"                         // ociLayoutPrefix selects an OCI image layout directory or tarball
+	dockerArchivePrefix       = "docker-archive:"              // dockerArchivePrefix selects a docker save tarball
+)
+
+// imageSource describes where the image attestations are read from: a registry reference or an offline OCI layout
+type imageSource struct {
+	Ref    string         // Registry reference, repo:tag or repo@sha256:digest
+	Layout string         // OCI layout directory or tarball, takes precedence over Ref
+	Opt    imagetools.Opt // Registry authentication and TLS options
+}
+
+// registryAuth implements imagetools.Auth with explicit credentials falling back to the docker config and credential helpers
+type registryAuth struct {
+	username string
+	token    string
+	registry string // explicit credentials only apply to this host when set
+	config   *configfile.ConfigFile
+}
+
+// GetAuthConfig returns the credentials for the registry host
+func (a *registryAuth) GetAuthConfig(registryHostname string) (clitypes.AuthConfig, error) {
+	if len(a.token) > 0 && (len(a.registry) == 0 || a.registry == registryHostname || (registryHostname == dockerHubAuthHost && a.registry == "docker.io")) {
+		username := a.username
+		if len(username) == 0 {
+			// Registries accepting bearer tokens ignore the user name but the basic auth header still needs one
+			username = "oauth2accesstoken"
+		}
+		return clitypes.AuthConfig{ServerAddress: registryHostname, Username: username, Password: a.token}, nil
+	}
+
+	if a.config == nil {
+		return clitypes.AuthConfig{ServerAddress: registryHostname}, nil
+	}
+	return a.config.GetAuthConfig(registryHostname)
+}
+
+// newRegistryAuth returns the explicit credentials backed by the docker config and credential helpers
+func newRegistryAuth(argv *argT) *registryAuth {
+	return &registryAuth{
+		username: argv.RegistryUser,
+		token:    argv.RegistryToken,
+		registry: argv.Registry,
+		config:   config.LoadDefaultConfigFile(io.Discard),
+	}
+}
+
+// newImageOpt builds the imagetools options from the docker config, explicit credentials and insecure registry lists
+func newImageOpt(argv *argT) imagetools.Opt {
+	auth := newRegistryAuth(argv)
+
+	registries := make(map[string]resolver.RegistryConfig, 0)
+	yes := true
+
+	for _, host := range splitList(argv.InsecureRegistry) {
+		cfg := registries[host]
+		cfg.Insecure = &yes
+		registries[host] = cfg
+	}
+
+	for _, host := range splitList(argv.PlainHTTPRegistry) {
+		cfg := registries[host]
+		cfg.PlainHTTP = &yes
+		registries[host] = cfg
+	}
+
+	return imagetools.Opt{Auth: auth, RegistryConfig: registries}
+}
+
+// splitList splits a comma separated flag value and drops the empty entries
+func splitList(val string) []string {
+	items := make([]string, 0)
+
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// openLayout opens an OCI image layout directory.  Tarballs from docker save or buildx --output type=oci are extracted to a temp directory first.
+func openLayout(source string) (layout.Path, func(), error) {
+	source = strings.TrimPrefix(strings.TrimPrefix(source, ociLayoutPrefix), dockerArchivePrefix)
+	cleanup := func() {}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", cleanup, err
+	}
+
+	if info.IsDir() {
+		return layout.Path(source), cleanup, nil
+	}
+
+	dir, err := os.MkdirTemp("", "scec-layout-")
+	if err != nil {
+		return "", cleanup, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	if err := extractTar(source, dir); err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err != nil {
+		cleanup()
+		return "", func() {}, fmt.Errorf("%s has no index.json, only OCI layouts and docker save tarballs from the containerd image store contain attestations", source)
+	}
+	return layout.Path(dir), cleanup, nil
+}
+
+// extractTar unpacks the tarball into the directory, refusing entries that escape it
+func extractTar(filename string, dir string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, hdr.Name)
+		if target != filepath.Clean(dir) && !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path %s in %s", hdr.Name, filename)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+
+			out, err := os.Create(target)
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			out.Close()
+		}
+	}
+}
+
+// layoutManifests walks the layout index descending into nested indexes.  It returns the platform manifests and the attestation manifests keyed by the digest of the platform manifest they describe.
+func layoutManifests(idx v1.ImageIndex) ([]v1.Descriptor, map[string]v1.Image, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	platforms := make([]v1.Descriptor, 0)
+	attestations := make(map[string]v1.Image, 0)
+
+	for _, desc := range manifest.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := idx.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			found, att, err := layoutManifests(child)
+			if err != nil {
+				return nil, nil, err
+			}
+			platforms = append(platforms, found...)
+			for k, v := range att {
+				attestations[k] = v
+			}
+		case desc.Annotations[referenceTypeAnnotation] == attestationManifest:
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return nil, nil, err
+			}
+			attestations[desc.Annotations[referenceDigestAnnotation]] = img
+		default:
+			if desc.Platform == nil {
+				// A lone image manifest without a platform in the descriptor, use the image config
+				img, err := idx.Image(desc.Digest)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				cfg, err := img.ConfigFile()
+				if err != nil {
+					return nil, nil, err
+				}
+				desc.Platform = cfg.Platform()
+			}
+			platforms = append(platforms, desc)
+		}
+	}
+	return platforms, attestations, nil
+}
+
+// decodeStatement returns the in-toto statement from a layer, unwrapping the DSSE envelope when needed
+func decodeStatement(data []byte, mediaType string) ([]byte, error) {
+	if mediaType == inTotoMediaType {
+		return data, nil
+	}
+
+	var envelope struct {
+		Payload string `json:"payload"`
+	}
+
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(envelope.Payload)
+}
+
+// readAttestation returns the SPDX SBOM and SLSA provenance predicates from an attestation manifest.
+// The results use the same json as the {{ json .SBOM.SPDX }} and {{ json .Provenance }} imagetools templates.
+func readAttestation(img v1.Image) (string, string, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return "", "", err
+	}
+
+	sbomString := ""
+	provenanceString := ""
+
+	for _, desc := range manifest.Layers {
+		mediaType := string(desc.MediaType)
+		predicateType := desc.Annotations[predicateTypeAnnotation]
+
+		if mediaType != inTotoMediaType && !strings.HasPrefix(mediaType, inTotoDSSEMediaTypePrefix) {
+			continue
+		}
+
+		isSBOM := predicateType == spdxPredicateType && len(sbomString) == 0
+		isProvenance := strings.HasPrefix(predicateType, slsaPredicatePrefix) && len(provenanceString) == 0
+		if !isSBOM && !isProvenance {
+			continue
+		}
+
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return "", "", err
+		}
+
+		rc, err := layer.Compressed()
+		if err != nil {
+			return "", "", err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", "", err
+		}
+
+		if data, err = decodeStatement(data, mediaType); err != nil {
+			return "", "", err
+		}
+
+		var statement struct {
+			Predicate json.RawMessage `json:"predicate"`
+		}
+		if err := json.Unmarshal(data, &statement); err != nil {
+			return "", "", err
+		}
+
+		if isSBOM {
+			sbomString = string(statement.Predicate)
+		} else {
+			provenanceString = fmt.Sprintf(`{"SLSA":%s}`, statement.Predicate)
+		}
+	}
+	return sbomString, provenanceString, nil
+}
+
+// getAttestationsFromLayout returns the SBOM, provenance and digest for every platform manifest of an OCI layout
+func getAttestationsFromLayout(source string) ([]platformAttestations, error) {
+	path, cleanup, err := openLayout(source)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	idx, err := path.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	platforms, attestations, err := layoutManifests(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]platformAttestations, 0)
+	for _, desc := range platforms {
+		pa := platformAttestations{Digest: desc.Digest.String(), Platform: "unknown"}
+		if desc.Platform != nil {
+			pa.Platform = platformString(desc.Platform.OS, desc.Platform.Architecture, desc.Platform.Variant)
+		}
+
+		// Older exporters do not annotate the subject digest, that is only unambiguous for a single platform
+		img, found := attestations[pa.Digest]
+		if !found && len(platforms) == 1 {
+			img, found = attestations[""]
+		}
+
+		if found {
+			if pa.SBOM, pa.Provenance, err = readAttestation(img); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, pa)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Platform < result[j].Platform })
+	return result, nil
+}
@@ -0,0 +1,363 @@
+// Package main - Run report as JSON, GitHub Actions step summary, GitLab dotenv and JUnit XML
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// evidenceItem is an upload of the run
+type evidenceItem struct {
+	Type       string `json:"type"` // sbom, provenance, vex, compver, ... or the document type
+	Name       string `json:"name,omitempty"`
+	Key        string `json:"key,omitempty"`
+	Status     string `json:"status"` // uploaded or failed
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// phaseTiming is the duration of a phase of the run
+type phaseTiming struct {
+	Phase      string `json:"phase"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// runReport is the machine readable result of a run
+type runReport struct {
+	Component  string            `json:"component"`
+	Version    string            `json:"version"`
+	Result     string            `json:"result"` // success or failure
+	Error      string            `json:"error,omitempty"`
+	Started    time.Time         `json:"started"`
+	DurationMS int64             `json:"duration_ms"`
+	Keys       map[string]string `json:"keys"`       // SBOM_KEY, PROVENANCE_KEY and COMPVER_KEY
+	Attributes map[string]string `json:"attributes"` // Derived from git and the environment
+	Evidence   []evidenceItem    `json:"evidence"`
+	Warnings   []string          `json:"warnings"`
+	Timings    []phaseTiming     `json:"timings"`
+}
+
+var (
+	reportMu sync.Mutex
+	report   = newRunReport()
+)
+
+// newRunReport is the contructor that sets the appropriate default values
+func newRunReport() *runReport {
+	return &runReport{
+		Started:    time.Now().UTC(),
+		Keys:       make(map[string]string, 0),
+		Attributes: make(map[string]string, 0),
+		Evidence:   make([]evidenceItem, 0),
+		Warnings:   make([]string, 0),
+		Timings:    make([]phaseTiming, 0),
+	}
+}
+
+// recordEvidence adds an upload to the report
+func recordEvidence(item evidenceItem) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	report.Evidence = append(report.Evidence, item)
+}
+
+// recordTiming adds the duration of a phase to the report
+func recordTiming(phase string, d time.Duration) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	report.Timings = append(report.Timings, phaseTiming{Phase: phase, DurationMS: d.Milliseconds()})
+}
+
+// recordKey sets a created key, empty keys are skipped
+func recordKey(name string, key string) {
+	if len(key) == 0 {
+		return
+	}
+
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	report.Keys[name] = key
+}
+
+// recordComponent sets the name, version and derived attributes of the component version.  The attributes are redacted, GIT_URL may hold a token.
+func recordComponent(name string, version string, attrs map[string]string) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+
+	report.Component = name
+	report.Version = version
+	for k, v := range attrs {
+		report.Attributes[k] = redact(v)
+	}
+}
+
+// finishReport sets the result and the duration of the run
+func finishReport(err error) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+
+	report.DurationMS = time.Since(report.Started).Milliseconds()
+	report.Result = "success"
+	if err != nil {
+		report.Result = "failure"
+		report.Error = redact(err.Error())
+	}
+}
+
+// reportHandler wraps the log handler and collects the warnings and errors for the report
+type reportHandler struct {
+	slog.Handler
+	attrs []slog.Attr
+}
+
+// Handle implements slog.Handler, the warning is the message and its attributes as key=value
+func (h *reportHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		parts := []string{r.Message}
+		add := func(a slog.Attr) bool {
+			a = redactAttr(nil, a)
+			parts = append(parts, fmt.Sprintf("%s=%v", a.Key, a.Value))
+			return true
+		}
+
+		for _, a := range h.attrs {
+			add(a)
+		}
+		r.Attrs(add)
+
+		reportMu.Lock()
+		report.Warnings = append(report.Warnings, strings.Join(parts, " "))
+		reportMu.Unlock()
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler
+func (h *reportHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &reportHandler{Handler: h.Handler.WithAttrs(attrs), attrs: append(append(make([]slog.Attr, 0), h.attrs...), attrs...)}
+}
+
+// WithGroup implements slog.Handler
+func (h *reportHandler) WithGroup(name string) slog.Handler {
+	return &reportHandler{Handler: h.Handler.WithGroup(name), attrs: h.attrs}
+}
+
+// writeJSONReport writes the report as indented JSON
+func writeJSONReport(filename string, r *runReport) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0o644)
+}
+
+// markdownCell escapes the pipes and newlines of a markdown table cell
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+// renderStepSummary renders the report as the markdown of a GitHub Actions step summary
+func renderStepSummary(r *runReport) string {
+	var b strings.Builder
+
+	icon := ":white_check_mark:"
+	if r.Result != "success" {
+		icon = ":x:"
+	}
+	fmt.Fprintf(&b, "## %s %s\n\n", icon, markdownCell(r.Component))
+
+	if len(r.Error) > 0 {
+		fmt.Fprintf(&b, "**Error:** %s\n\n", markdownCell(r.Error))
+	}
+
+	b.WriteString("| Key | Value |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Version | %s |\n", markdownCell(r.Version))
+	for _, k := range sortedKeys(r.Keys) {
+		fmt.Fprintf(&b, "| %s | `%s` |\n", k, markdownCell(r.Keys[k]))
+	}
+	fmt.Fprintf(&b, "| Duration | %.1fs |\n\n", float64(r.DurationMS)/1000)
+
+	if len(r.Evidence) > 0 {
+		b.WriteString("### Evidence\n\n| Type | Name | Status | Key | Duration |\n|---|---|---|---|---|\n")
+		for _, e := range r.Evidence {
+			status := e.Status
+			if len(e.Error) > 0 {
+				status += ": " + e.Error
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %dms |\n", e.Type, markdownCell(e.Name), markdownCell(status), markdownCell(e.Key), e.DurationMS)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(r.Warnings) > 0 {
+		fmt.Fprintf(&b, "<details><summary>%d warnings</summary>\n\n", len(r.Warnings))
+		for _, w := range r.Warnings {
+			fmt.Fprintf(&b, "- %s\n", markdownCell(w))
+		}
+		b.WriteString("\n</details>\n\n")
+	}
+
+	if len(r.Timings) > 0 {
+		b.WriteString("<details><summary>Timings</summary>\n\n| Phase | Duration |\n|---|---|\n")
+		for _, t := range r.Timings {
+			fmt.Fprintf(&b, "| %s | %dms |\n", t.Phase, t.DurationMS)
+		}
+		b.WriteString("\n</details>\n")
+	}
+	return b.String()
+}
+
+// appendFile appends to the file, GitHub Actions shares the step summary file between the commands of a step
+func appendFile(filename string, content string) error {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// renderDotenv renders the created keys as a GitLab dotenv report, the variables are available to the later jobs
+func renderDotenv(r *runReport) string {
+	var b strings.Builder
+	for _, k := range []string{"SBOM_KEY", "PROVENANCE_KEY", "COMPVER_KEY"} {
+		fmt.Fprintf(&b, "%s=%s\n", k, r.Keys[k])
+	}
+	return b.String()
+}
+
+// junitFailure marks a failed evidence upload
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitTestCase is an evidence item of the run
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+// junitTestSuite is the run, the warnings go to the system-out
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+	SystemOut string          `xml:"system-out,omitempty"`
+}
+
+// renderJUnit renders every evidence item as a test case.  A run that fails outside of an upload gets a failed run test case.
+func renderJUnit(r *runReport) ([]byte, error) {
+	seconds := func(ms int64) string { return fmt.Sprintf("%.3f", float64(ms)/1000) }
+
+	suite := junitTestSuite{
+		Name:      strings.TrimSpace("scec-cli " + r.Component),
+		Time:      seconds(r.DurationMS),
+		Timestamp: r.Started.Format("2006-01-02T15:04:05"),
+		TestCases: make([]junitTestCase, 0),
+		SystemOut: strings.Join(r.Warnings, "\n"),
+	}
+
+	uploadFailed := false
+	for _, e := range r.Evidence {
+		name := e.Type
+		if len(e.Name) > 0 {
+			name += " " + e.Name
+		}
+
+		tc := junitTestCase{Name: name, Classname: "evidence." + e.Type, Time: seconds(e.DurationMS)}
+		if e.Status != "uploaded" {
+			tc.Failure = &junitFailure{Message: e.Error, Type: e.Status, Text: e.Error}
+			uploadFailed = true
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	if r.Result != "success" && !uploadFailed {
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			Name:      "run",
+			Classname: "evidence.run",
+			Time:      seconds(r.DurationMS),
+			Failure:   &junitFailure{Message: r.Error, Type: r.Result, Text: r.Error},
+		})
+	}
+
+	suite.Tests = len(suite.TestCases)
+	for _, tc := range suite.TestCases {
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+	}
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// sortedKeys returns the keys of the map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeReports writes the report in every requested format.  A report that can not be written is logged, it does not fail the run.
+func writeReports(argv *argT) {
+	// Copy the report, logging a write error adds a warning to it
+	reportMu.Lock()
+	r := *report
+	reportMu.Unlock()
+
+	if len(argv.Report) > 0 {
+		if err := writeJSONReport(argv.Report, &r); err != nil {
+			slog.Error("could not write the report", "file", argv.Report, "error", err)
+		}
+	}
+
+	if len(argv.StepSummary) > 0 {
+		if err := appendFile(argv.StepSummary, renderStepSummary(&r)); err != nil {
+			slog.Error("could not write the step summary", "file", argv.StepSummary, "error", err)
+		}
+	}
+
+	if len(argv.Dotenv) > 0 {
+		if err := os.WriteFile(argv.Dotenv, []byte(renderDotenv(&r)), 0o644); err != nil {
+			slog.Error("could not write the dotenv report", "file", argv.Dotenv, "error", err)
+		}
+	}
+
+	if len(argv.JUnit) > 0 {
+		data, err := renderJUnit(&r)
+		if err == nil {
+			err = os.WriteFile(argv.JUnit, data, 0o644)
+		}
+
+		if err != nil {
+			slog.Error("could not write the JUnit report", "file", argv.JUnit, "error", err)
+		}
+	}
+}
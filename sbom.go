@@ -0,0 +1,606 @@
+// Package main - SBOM normalization into content addressed nodes
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mkideal/cli"
+)
+
+// cidPrefix is the CIDv1 header for a raw codec sha256 multihash (version 0x01, codec 0x55, sha2-256 0x12, length 0x20)
+var cidPrefix = []byte{0x01, 0x55, 0x12, 0x20}
+
+// cidEncoding is the multibase base32 alphabet used by CIDv1 (lowercase, no padding)
+var cidEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// sbomNode is a single content addressed piece of a decomposed SBOM
+type sbomNode struct {
+	Path    string // JSON path of the node, "root" for the document itself
+	CID     string // CIDv1 of the node content
+	Content []byte // node content with nested objects and arrays replaced by their CIDs
+}
+
+// makeCID returns the base32 CIDv1 (raw codec, sha256 multihash) for the data
+func makeCID(data []byte) string {
+	digest := sha256.Sum256(data)
+	return "b" + cidEncoding.EncodeToString(append(append([]byte{}, cidPrefix...), digest[:]...))
+}
+
+// canonicalJSON marshals the value with sorted keys and without HTML escaping so that equal content always hashes the same
+func canonicalJSON(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// decodeJSON parses the data keeping numbers as json.Number so they round trip unchanged
+func decodeJSON(data []byte) (interface{}, error) {
+	var doc interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// sbomFormat returns "CycloneDX" or "SPDX" based on the well known root keys of the document
+func sbomFormat(doc interface{}) string {
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	if format, ok := root["bomFormat"].(string); ok && format == "CycloneDX" {
+		return "CycloneDX"
+	}
+
+	if _, ok := root["spdxVersion"]; ok {
+		return "SPDX"
+	}
+	return ""
+}
+
+// The node content keeps every scalar as a string and every link as its CID, the encoding of the out.txt reference mapping.
+// The shape of the document records what the content can not: which values are links and the JSON type of the scalars that are not strings.
+//   - the shape of an object node is an object with an entry for each link or non string scalar, strings are left out
+//   - the shape of an array node is an array with an entry for each item
+//   - a link has the shape of the linked node, a scalar is "string", "number", "boolean" or "null"
+//
+// {"metadata":{"tools":[{}]},"version":"number"} is the shape of mapping.txt.
+
+// scalarText returns the string the scalar is stored as in its node and its kind for the shape
+func scalarText(v interface{}) (string, string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, "string", nil
+	case json.Number:
+		return t.String(), "number", nil
+	case bool:
+		return strconv.FormatBool(t), "boolean", nil
+	case nil:
+		return "null", "null", nil
+	}
+	return "", "", fmt.Errorf("unsupported JSON value %T", v)
+}
+
+// typedScalar turns the stored string back into a scalar of the kind recorded in the shape, a missing kind is a string
+func typedScalar(text string, kind interface{}) (interface{}, error) {
+	switch kind {
+	case nil, "string":
+		return text, nil
+	case "number":
+		v, err := decodeJSON([]byte(text))
+		if _, ok := v.(json.Number); err != nil || !ok {
+			return nil, fmt.Errorf("invalid number %q", text)
+		}
+		return v, nil
+	case "boolean":
+		if text != "true" && text != "false" {
+			return nil, fmt.Errorf("invalid boolean %q", text)
+		}
+		return text == "true", nil
+	case "null":
+		if text != "null" {
+			return nil, fmt.Errorf("invalid null %q", text)
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown kind %v in the shape", kind)
+}
+
+// splitSBOM walks the document depth first and returns the nodes, children before parents, with the root node last, and the shape of the document.
+// Objects are stored as canonical JSON with the links as strings, arrays as [cid,"scalar"] with the links bare.
+func splitSBOM(doc interface{}) ([]sbomNode, interface{}, error) {
+	nodes := make([]sbomNode, 0)
+
+	// walk returns the text of the value in its parent node and the shape of the value
+	var walk func(nodePath string, v interface{}) (string, interface{}, error)
+	walk = func(nodePath string, v interface{}) (string, interface{}, error) {
+		var data []byte
+		var shape interface{}
+
+		switch t := v.(type) {
+		case map[string]interface{}:
+			obj := make(map[string]string, len(t))
+			fields := make(map[string]interface{}, 0)
+
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				text, childShape, err := walk(joinNodePath(nodePath, k), t[k])
+				if err != nil {
+					return "", nil, err
+				}
+
+				obj[k] = text
+				if childShape != "string" {
+					fields[k] = childShape
+				}
+			}
+
+			var err error
+			if data, err = canonicalJSON(obj); err != nil {
+				return "", nil, err
+			}
+			shape = fields
+		case []interface{}:
+			items := make([]string, len(t))
+			kinds := make([]interface{}, len(t))
+
+			for i, item := range t {
+				text, childShape, err := walk(joinNodePath(nodePath, fmt.Sprintf("%03d", i)), item)
+				if err != nil {
+					return "", nil, err
+				}
+
+				if _, isScalar := childShape.(string); isScalar {
+					quoted, err := canonicalJSON(text)
+					if err != nil {
+						return "", nil, err
+					}
+					text = string(quoted)
+				}
+				items[i] = text
+				kinds[i] = childShape
+			}
+			data = []byte("[" + strings.Join(items, ",") + "]")
+			shape = kinds
+		default:
+			// Scalars stay inline in their parent node
+			return scalarText(v)
+		}
+
+		cid := makeCID(data)
+		name := nodePath
+		if len(name) == 0 {
+			name = "root"
+		}
+		nodes = append(nodes, sbomNode{Path: name, CID: cid, Content: data})
+		return cid, shape, nil
+	}
+
+	_, shape, err := walk("", doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, isScalar := shape.(string); isScalar {
+		return nil, nil, fmt.Errorf("the document is a scalar, not an object or array")
+	}
+	return nodes, shape, nil
+}
+
+// parseNodeArray reads the [cid,"scalar"] array form back into the bare links and the quoted scalars
+func parseNodeArray(data []byte) ([]string, error) {
+	body := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(body, "[") || !strings.HasSuffix(body, "]") {
+		return nil, fmt.Errorf("invalid array node %s", body)
+	}
+	body = body[1 : len(body)-1]
+
+	items := make([]string, 0)
+	for len(body) > 0 {
+		if body[0] == '"' {
+			// Quoted scalar, let the json decoder find the end of the string
+			dec := json.NewDecoder(strings.NewReader(body))
+			var item json.RawMessage
+			if err := dec.Decode(&item); err != nil {
+				return nil, err
+			}
+			items = append(items, string(item))
+			body = body[dec.InputOffset():]
+		} else {
+			item, _, _ := strings.Cut(body, ",")
+			items = append(items, item)
+			body = body[len(item):]
+		}
+		body = strings.TrimPrefix(body, ",")
+	}
+	return items, nil
+}
+
+// joinNodePath appends a key to a dotted JSON path
+func joinNodePath(parent string, key string) string {
+	if len(parent) == 0 {
+		return key
+	}
+	return parent + "." + key
+}
+
+// writeNodeStore saves each node under its CID in the store directory.  Nodes already present are not rewritten so identical sub-objects are only stored once across SBOMs.
+func writeNodeStore(store string, nodes []sbomNode) (int, error) {
+	if err := os.MkdirAll(store, 0o755); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for _, node := range nodes {
+		filename := filepath.Join(store, node.CID)
+
+		if _, err := os.Stat(filename); err == nil {
+			continue
+		}
+
+		if err := os.WriteFile(filename, node.Content, 0o644); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// writeMapping writes the "path cid=content" lines in the same layout as out.txt.  An empty filename writes to stdout.
+func writeMapping(filename string, nodes []sbomNode) error {
+	buf := new(bytes.Buffer)
+
+	for _, node := range nodes {
+		fmt.Fprintf(buf, "%s %s=%s\n", node.Path, node.CID, node.Content)
+	}
+
+	if len(filename) == 0 {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(filename, buf.Bytes(), 0o644)
+}
+
+// readMapping loads the nodes from a mapping file written by writeMapping
+func readMapping(filename string) (map[string][]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nodes := make(map[string][]byte, 0)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		_, rest, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("invalid mapping line: %s", line)
+		}
+
+		cid, content, found := strings.Cut(rest, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid mapping line: %s", line)
+		}
+		nodes[cid] = []byte(content)
+	}
+	return nodes, scanner.Err()
+}
+
+// loadNode returns the content for the CID from the mapping nodes or the node store directory
+func loadNode(cid string, nodes map[string][]byte, store string) ([]byte, bool) {
+	if data, found := nodes[cid]; found {
+		return data, true
+	}
+
+	if len(store) == 0 {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(store, cid))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// shapeFilename returns the shape file written next to the mapping, ie out.shape.json for out.txt
+func shapeFilename(mappingFile string) string {
+	return strings.TrimSuffix(mappingFile, filepath.Ext(mappingFile)) + ".shape.json"
+}
+
+// writeShape saves the shape of the document as canonical JSON
+func writeShape(filename string, shape interface{}) error {
+	data, err := canonicalJSON(shape)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0o644)
+}
+
+// storeShape saves the shape in the node store as ROOT.SHAPECID.shape.json.  Documents that only differ in the scalar types share the root CID but not the shape.
+func storeShape(store string, rootCID string, shape interface{}) error {
+	data, err := canonicalJSON(shape)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(store, rootCID+"."+makeCID(data)+".shape.json"), append(data, '\n'), 0o644)
+}
+
+// findShape reads the shape from the file given, the shape next to the mapping or the one stored with the root node
+func findShape(shapeFile string, mappingFile string, store string, rootCID string) (interface{}, error) {
+	if len(shapeFile) == 0 && len(mappingFile) > 0 {
+		if _, err := os.Stat(shapeFilename(mappingFile)); err == nil {
+			shapeFile = shapeFilename(mappingFile)
+		}
+	}
+
+	if len(shapeFile) == 0 && len(store) > 0 {
+		stored, _ := filepath.Glob(filepath.Join(store, rootCID+".*.shape.json"))
+		if len(stored) > 1 {
+			return nil, fmt.Errorf("%d shapes are stored for %s, use --shape to pick one", len(stored), rootCID)
+		}
+
+		if len(stored) == 1 {
+			shapeFile = stored[0]
+		}
+	}
+
+	if len(shapeFile) == 0 {
+		return nil, fmt.Errorf("no shape found for %s, use --shape so the links and scalar types can be restored", rootCID)
+	}
+
+	data, err := os.ReadFile(shapeFile)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(data)
+}
+
+// rebuildSBOM reassembles the original document starting from the root CID.  The shape says which values are links, the content is never guessed at.
+func rebuildSBOM(rootCID string, shape interface{}, nodes map[string][]byte, store string) (interface{}, error) {
+	var resolve func(cid string, shape interface{}) (interface{}, error)
+	resolve = func(cid string, shape interface{}) (interface{}, error) {
+		data, found := loadNode(cid, nodes, store)
+		if !found {
+			return nil, fmt.Errorf("node %s not found", cid)
+		}
+
+		if makeCID(data) != cid {
+			return nil, fmt.Errorf("node %s content does not match its CID", cid)
+		}
+
+		// expand follows a link or types a scalar, whichever the shape says the value is
+		expand := func(text string, childShape interface{}) (interface{}, error) {
+			switch childShape.(type) {
+			case map[string]interface{}, []interface{}:
+				return resolve(text, childShape)
+			}
+			return typedScalar(text, childShape)
+		}
+
+		switch s := shape.(type) {
+		case []interface{}:
+			items, err := parseNodeArray(data)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(items) != len(s) {
+				return nil, fmt.Errorf("array node %s has %d items, the shape %d", cid, len(items), len(s))
+			}
+
+			arr := make([]interface{}, len(items))
+			for i, item := range items {
+				text := item
+				if strings.HasPrefix(item, `"`) {
+					if err := json.Unmarshal([]byte(item), &text); err != nil {
+						return nil, fmt.Errorf("invalid array node %s: %v", cid, err)
+					}
+				}
+
+				if arr[i], err = expand(text, s[i]); err != nil {
+					return nil, err
+				}
+			}
+			return arr, nil
+		case map[string]interface{}:
+			var fields map[string]string
+			if err := json.Unmarshal(data, &fields); err != nil {
+				return nil, fmt.Errorf("invalid object node %s: %v", cid, err)
+			}
+
+			obj := make(map[string]interface{}, len(fields))
+			for k, text := range fields {
+				var err error
+				if obj[k], err = expand(text, s[k]); err != nil {
+					return nil, err
+				}
+			}
+			return obj, nil
+		}
+		return nil, fmt.Errorf("the shape of node %s is not an object or array", cid)
+	}
+
+	return resolve(rootCID, shape)
+}
+
+// normalizeSBOM splits the SBOM file into nodes, saves them with the shape and verifies that they rebuild the original document
+func normalizeSBOM(filename string, mappingFile string, shapeFile string, store string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return "", fmt.Errorf("could not parse %s: %v", filename, err)
+	}
+
+	format := sbomFormat(doc)
+	if len(format) == 0 {
+		return "", fmt.Errorf("%s is not a CycloneDX or SPDX json document", filename)
+	}
+
+	nodes, shape, err := splitSBOM(doc)
+	if err != nil {
+		return "", err
+	}
+	rootCID := nodes[len(nodes)-1].CID
+
+	if err := writeMapping(mappingFile, nodes); err != nil {
+		return "", err
+	}
+
+	if len(shapeFile) == 0 && len(mappingFile) > 0 {
+		shapeFile = shapeFilename(mappingFile)
+	}
+
+	if len(shapeFile) > 0 {
+		if err := writeShape(shapeFile, shape); err != nil {
+			return "", err
+		}
+	}
+
+	if len(store) > 0 {
+		written, err := writeNodeStore(store, nodes)
+		if err != nil {
+			return "", err
+		}
+
+		if err := storeShape(store, rootCID, shape); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(os.Stderr, "Stored %d new of %d nodes in %s\n", written, len(nodes), store)
+	}
+
+	if len(shapeFile) == 0 && len(store) == 0 {
+		fmt.Fprintf(os.Stderr, "The shape was not saved, use --shape or --store to be able to rebuild %s\n", filename)
+	}
+
+	// Rebuild from the nodes to prove the decomposition is lossless
+	lookup := make(map[string][]byte, len(nodes))
+	for _, node := range nodes {
+		lookup[node.CID] = node.Content
+	}
+
+	rebuilt, err := rebuildSBOM(rootCID, shape, lookup, "")
+	if err != nil {
+		return "", err
+	}
+
+	original, _ := canonicalJSON(doc)
+	roundtrip, _ := canonicalJSON(rebuilt)
+	if !bytes.Equal(original, roundtrip) {
+		return "", fmt.Errorf("rebuilt %s does not match the original document", filename)
+	}
+
+	fmt.Fprintf(os.Stderr, "Normalized %s %s into %d nodes, root %s\n", format, filename, len(nodes), rootCID)
+	return rootCID, nil
+}
+
+type sbomNormalizeT struct {
+	cli.Helper
+	File    string `cli:"*f,file" usage:"CycloneDX or SPDX Json Filename (required)"`
+	Mapping string `cli:"m,mapping" usage:"Mapping output filename, defaults to stdout"`
+	Shape   string `cli:"t,shape" usage:"Shape output filename, defaults to the mapping filename with .shape.json"`
+	Store   string `cli:"s,store" usage:"Directory for the content addressed node store"`
+}
+
+type sbomRebuildT struct {
+	cli.Helper
+	Root    string `cli:"*r,root" usage:"Root CID of the SBOM (required)"`
+	Mapping string `cli:"m,mapping" usage:"Mapping filename to read nodes from"`
+	Shape   string `cli:"t,shape" usage:"Shape filename, defaults to the one next to the mapping or in the store"`
+	Store   string `cli:"s,store" usage:"Directory for the content addressed node store"`
+	Output  string `cli:"o,output" usage:"Output filename, defaults to stdout"`
+}
+
+var sbomCmd = &cli.Command{
+	Name: "sbom",
+	Desc: "SBOM utilities",
+	Fn: func(ctx *cli.Context) error {
+		ctx.WriteUsage()
+		return nil
+	},
+}
+
+var sbomNormalizeCmd = &cli.Command{
+	Name: "normalize",
+	Desc: "Split a SBOM into content addressed nodes and write the CID mapping",
+	Argv: func() interface{} { return new(sbomNormalizeT) },
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*sbomNormalizeT)
+
+		_, err := normalizeSBOM(argv.File, argv.Mapping, argv.Shape, argv.Store)
+		return err
+	},
+}
+
+var sbomRebuildCmd = &cli.Command{
+	Name: "rebuild",
+	Desc: "Rebuild a SBOM from its content addressed nodes",
+	Argv: func() interface{} { return new(sbomRebuildT) },
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*sbomRebuildT)
+
+		nodes := make(map[string][]byte, 0)
+		if len(argv.Mapping) > 0 {
+			var err error
+			if nodes, err = readMapping(argv.Mapping); err != nil {
+				return err
+			}
+		}
+
+		shape, err := findShape(argv.Shape, argv.Mapping, argv.Store, argv.Root)
+		if err != nil {
+			return err
+		}
+
+		doc, err := rebuildSBOM(argv.Root, shape, nodes, argv.Store)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if len(argv.Output) == 0 {
+			fmt.Println(string(data))
+			return nil
+		}
+		return os.WriteFile(argv.Output, append(data, '\n'), 0o644)
+	},
+}
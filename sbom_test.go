@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSBOMRoundtrip(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"typed scalars in an array object", `{"components":[{"x":true,"n":null,"w":3.5}]}`},
+		{"scalars in an array", `{"list":["a",1,false,null,"b,c]",2.50]}`},
+		{"large and exact numbers", `{"version":1,"size":12345678901234567890,"ratio":1e-7}`},
+		{"strings that look like other types", `{"version":"1","flag":"true","none":"null","list":["1","false"]}`},
+		{"nested arrays and empty containers", `{"matrix":[[1,2],[],[{}]],"empty":{}}`},
+		{"strings that are CIDs of stored nodes", `{"a":{"k":"v"},"ref":"bafkreidgnqnkaluanddnlta5gkkqbfbsyftzbpwcr3em4em5bunbrvqtde","arr":["bafkreidgnqnkaluanddnlta5gkkqbfbsyftzbpwcr3em4em5bunbrvqtde"]}`},
+		{"escaped strings", `{"name":"a \"quoted\" <name>","path":"c:\\tmp\\x","unicode":"\u00e9"}`},
+		{"identical sub-objects", `{"a":{"k":"v"},"b":{"k":"v"},"c":[{"k":"v"},{"k":"v"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := decodeJSON([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nodes, shape, err := splitSBOM(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			root := nodes[len(nodes)-1]
+			if root.Path != "root" {
+				t.Errorf("last node Path = %q, want root", root.Path)
+			}
+
+			lookup := make(map[string][]byte, len(nodes))
+			for _, node := range nodes {
+				if makeCID(node.Content) != node.CID {
+					t.Errorf("node %s CID = %s, want %s", node.Path, node.CID, makeCID(node.Content))
+				}
+				lookup[node.CID] = node.Content
+			}
+
+			rebuilt, err := rebuildSBOM(root.CID, shape, lookup, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			original, _ := canonicalJSON(doc)
+			roundtrip, _ := canonicalJSON(rebuilt)
+			if !bytes.Equal(original, roundtrip) {
+				t.Errorf("rebuilt = %s, want %s", roundtrip, original)
+			}
+		})
+	}
+}
+
+func TestNormalizeSBOMFixture(t *testing.T) {
+	dir := t.TempDir()
+	mapping := filepath.Join(dir, "out.txt")
+	store := filepath.Join(dir, "nodes")
+
+	rootCID, err := normalizeSBOM("mapping.txt", mapping, "", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := os.ReadFile("out.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("mapping = %s, want %s", got, want)
+	}
+
+	shape, err := os.ReadFile(filepath.Join(dir, "out.shape.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wantShape := `{"metadata":{"tools":[{}]},"version":"number"}` + "\n"; string(shape) != wantShape {
+		t.Errorf("shape = %s, want %s", shape, wantShape)
+	}
+
+	// Rebuild from the store alone, the way the rebuild command does without the mapping
+	stored, err := findShape("", "", store, rootCID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt, err := rebuildSBOM(rootCID, stored, map[string][]byte{}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile("mapping.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := decodeJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, _ := canonicalJSON(doc)
+	roundtrip, _ := canonicalJSON(rebuilt)
+	if !bytes.Equal(original, roundtrip) {
+		t.Errorf("rebuilt = %s, want %s", roundtrip, original)
+	}
+}
+
+func TestRebuildSBOMErrors(t *testing.T) {
+	nodes, err := readMapping("out.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := "bafkreieubpgbf36s57qwj5h2cxbdtwl24t3uciyb43ip3ru6347nbydm4u"
+	shape := map[string]interface{}{"metadata": map[string]interface{}{"tools": []interface{}{map[string]interface{}{}}}, "version": "number"}
+
+	if _, err := rebuildSBOM(root, shape, nodes, ""); err != nil {
+		t.Fatalf("rebuildSBOM(out.txt) = %v", err)
+	}
+
+	wrongShape := map[string]interface{}{"metadata": map[string]interface{}{"tools": []interface{}{}}, "version": "number"}
+	if _, err := rebuildSBOM(root, wrongShape, nodes, ""); err == nil {
+		t.Error("rebuildSBOM with a shape that does not fit succeeded, want an error")
+	}
+
+	typedShape := map[string]interface{}{"metadata": map[string]interface{}{"tools": []interface{}{map[string]interface{}{}}}, "specVersion": "boolean"}
+	if _, err := rebuildSBOM(root, typedShape, nodes, ""); err == nil {
+		t.Error("rebuildSBOM with 1.4 as a boolean succeeded, want an error")
+	}
+
+	for cid, content := range nodes {
+		nodes[cid] = bytes.Replace(content, []byte("syft"), []byte("grype"), 1)
+	}
+
+	if _, err := rebuildSBOM(root, shape, nodes, ""); err == nil {
+		t.Error("rebuildSBOM with a tampered node succeeded, want a CID mismatch error")
+	}
+}
@@ -0,0 +1,327 @@
+// Package main - DSSE signing of the evidence payloads with PEM or PKCS#11 keys and the verify command
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mkideal/cli"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+// payloadTypePrefix is the DSSE payload type prefix of the Ortelius evidence, the object type and +json follow
+const payloadTypePrefix = "application/vnd.ortelius."
+
+// keySigner is a dsse.Signer for ed25519, ECDSA and RSA keys held in memory or in a PKCS#11 token
+type keySigner struct {
+	key   crypto.Signer
+	keyID string
+}
+
+// Sign signs the DSSE pre-authentication encoding.  ed25519 signs the message, ECDSA and RSA sign its sha256.
+func (s *keySigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	if _, ok := s.key.Public().(ed25519.PublicKey); ok {
+		return s.key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(data)
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// KeyID is the ssh style sha256 fingerprint of the public key
+func (s *keySigner) KeyID() (string, error) {
+	return s.keyID, nil
+}
+
+// keyVerifier is a dsse.Verifier for a trusted ed25519, ECDSA or RSA public key
+type keyVerifier struct {
+	pub   crypto.PublicKey
+	keyID string
+}
+
+// Verify checks the signature over the DSSE pre-authentication encoding
+func (v *keyVerifier) Verify(ctx context.Context, data []byte, sig []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch pub := v.pub.(type) {
+	case ed25519.PublicKey:
+		if ed25519.Verify(pub, data, sig) {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err == nil {
+			return nil
+		}
+		if err := rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, nil); err == nil {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", v.pub)
+	}
+	return fmt.Errorf("signature does not match key %s", v.keyID)
+}
+
+// KeyID is the ssh style sha256 fingerprint of the public key
+func (v *keyVerifier) KeyID() (string, error) {
+	return v.keyID, nil
+}
+
+// Public returns the trusted public key
+func (v *keyVerifier) Public() crypto.PublicKey {
+	return v.pub
+}
+
+// newKeySigner wraps the key and derives its key id
+func newKeySigner(key crypto.Signer) (*keySigner, error) {
+	keyID, err := dsse.SHA256KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &keySigner{key: key, keyID: keyID}, nil
+}
+
+// loadPrivateKey reads a PKCS#8, PKCS#1 or SEC 1 PEM private key
+func loadPrivateKey(filename string) (crypto.Signer, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		var key interface{}
+
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, fmt.Errorf("%s is encrypted, decrypt it or use a PKCS#11 token", filename)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", filename, err)
+		}
+
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T in %s", key, filename)
+	}
+	return nil, fmt.Errorf("no private key found in %s", filename)
+}
+
+// loadPublicKeys reads the trusted public keys from PEM files, certificates or private keys.  Directories are searched for *.pem and *.pub.
+func loadPublicKeys(patterns []string) ([]dsse.Verifier, error) {
+	verifiers := make([]dsse.Verifier, 0)
+
+	files := make([]string, 0)
+	for _, pattern := range patterns {
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			for _, ext := range []string{"*.pem", "*.pub", "*.crt"} {
+				matches, _ := filepath.Glob(filepath.Join(pattern, ext))
+				files = append(files, matches...)
+			}
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	for _, filename := range files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			var pub crypto.PublicKey
+
+			switch block.Type {
+			case "PUBLIC KEY":
+				pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+			case "RSA PUBLIC KEY":
+				pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+			case "CERTIFICATE":
+				var cert *x509.Certificate
+				if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+					pub = cert.PublicKey
+				}
+			case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+				var key crypto.Signer
+				if key, err = loadPrivateKey(filename); err == nil {
+					pub = key.Public()
+				}
+			default:
+				continue
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("could not parse %s: %v", filename, err)
+			}
+
+			keyID, err := dsse.SHA256KeyID(pub)
+			if err != nil {
+				return nil, fmt.Errorf("unsupported key in %s: %v", filename, err)
+			}
+			verifiers = append(verifiers, &keyVerifier{pub: pub, keyID: keyID})
+		}
+	}
+
+	if len(verifiers) == 0 {
+		return nil, fmt.Errorf("no public keys found in %s", strings.Join(patterns, ", "))
+	}
+	return verifiers, nil
+}
+
+// newEvidenceSigner returns the DSSE signer for the key file or PKCS#11 token flags, nil when signing is not configured.
+// The returned func releases the PKCS#11 session and must be called once the evidence is signed.
+func newEvidenceSigner(argv *SignFlagsT) (*dsse.EnvelopeSigner, func(), error) {
+	var key crypto.Signer
+	closer := func() {}
+
+	switch {
+	case len(argv.SignKey) > 0:
+		var err error
+		if key, err = loadPrivateKey(argv.SignKey); err != nil {
+			return nil, nil, err
+		}
+	case len(argv.PKCS11Module) > 0:
+		hsmKey, err := loadPKCS11Key(argv.PKCS11Module, argv.PKCS11Token, argv.PKCS11Label, argv.PKCS11Pin)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, closer = hsmKey, hsmKey.Close
+	default:
+		return nil, closer, nil
+	}
+
+	signer, err := newKeySigner(key)
+	if err != nil {
+		closer()
+		return nil, nil, err
+	}
+
+	envelopeSigner, err := dsse.NewEnvelopeSigner(signer)
+	if err != nil {
+		closer()
+		return nil, nil, err
+	}
+	return envelopeSigner, closer, nil
+}
+
+// signPayload wraps the payload in a signed DSSE envelope.  Without a signer the payload is returned as is.
+func signPayload(signer *dsse.EnvelopeSigner, payloadType string, payload interface{}) (interface{}, error) {
+	if signer == nil {
+		return payload, nil
+	}
+
+	body, ok := payload.([]byte)
+	if !ok {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	return signer.SignPayload(context.Background(), payloadType, body)
+}
+
+// evidencePayloadType returns the DSSE payload type of an evidence object, ie application/vnd.ortelius.sbom+json
+func evidencePayloadType(objType string) string {
+	return payloadTypePrefix + objType + "+json"
+}
+
+// verifyEnvelope checks the envelope signatures against the trusted keys and returns the accepted key ids
+func verifyEnvelope(data []byte, verifiers []dsse.Verifier, threshold int) (*dsse.Envelope, []string, error) {
+	envelope := new(dsse.Envelope)
+	if err := json.Unmarshal(data, envelope); err != nil {
+		return nil, nil, fmt.Errorf("not a DSSE envelope: %v", err)
+	}
+
+	if len(envelope.PayloadType) == 0 || len(envelope.Payload) == 0 {
+		return nil, nil, fmt.Errorf("not a DSSE envelope, payloadType and payload are required")
+	}
+
+	ev, err := dsse.NewMultiEnvelopeVerifier(threshold, verifiers...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accepted, err := ev.Verify(context.Background(), envelope)
+
+	keyIDs := make([]string, 0)
+	for _, key := range accepted {
+		keyIDs = append(keyIDs, key.KeyID)
+	}
+	return envelope, keyIDs, err
+}
+
+type verifyT struct {
+	cli.Helper
+	Envelope  string `cli:"*envelope" usage:"DSSE envelope json filename (required)"`
+	Keys      string `cli:"*keys" usage:"Comma separated trusted public key PEM files, certificates or directories (required)"`
+	Threshold int    `cli:"threshold" usage:"Number of trusted keys that have to sign the envelope" dft:"1"`
+	Payload   string `cli:"payload" usage:"Write the verified payload to this filename"`
+}
+
+var verifyCmd = &cli.Command{
+	Name: "verify",
+	Desc: "Verify the signatures of a DSSE envelope against a set of trusted keys",
+	Argv: func() interface{} { return new(verifyT) },
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*verifyT)
+
+		verifiers, err := loadPublicKeys(splitList(argv.Keys))
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(argv.Envelope)
+		if err != nil {
+			return err
+		}
+
+		envelope, keyIDs, err := verifyEnvelope(data, verifiers, argv.Threshold)
+		if err != nil {
+			return fmt.Errorf("%s: %v", argv.Envelope, err)
+		}
+
+		for _, keyID := range keyIDs {
+			fmt.Printf("Verified signature from %s\n", keyID)
+		}
+		fmt.Printf("%s is a valid %s envelope\n", argv.Envelope, envelope.PayloadType)
+
+		if len(argv.Payload) > 0 {
+			payload, err := envelope.DecodeB64Payload()
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(argv.Payload, payload, 0o644)
+		}
+		return nil
+	},
+}
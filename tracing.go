@@ -0,0 +1,138 @@
+// Package main - OpenTelemetry tracing of the run, exported over OTLP or to a local file, with the trace context propagated to the msapi services
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	resty "github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracer   = otel.Tracer("scec-cli")
+	runCtx   = context.Background() // runCtx holds the root span of the run
+	traceCtx = context.Background() // traceCtx holds the span of the current phase, the CLI runs one phase at a time
+)
+
+// setupTracing installs the tracer provider exporting to the OTLP endpoint, ie http://collector:4318, and to the trace file as JSON lines.
+// Without either the spans are not recorded.  The returned func flushes the spans and must be called before exiting.
+func setupTracing(endpoint string, file string) (func(), error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if len(endpoint) == 0 && len(file) == 0 {
+		return func() {}, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "scec-cli")))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	closers := make([]func() error, 0)
+
+	if len(endpoint) > 0 {
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTLP endpoint %s: %v", endpoint, err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	if len(file) > 0 {
+		f, err := os.Create(file)
+		if err != nil {
+			return nil, err
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+		closers = append(closers, f.Close)
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not export the traces: %v\n", err)
+		}
+		for _, c := range closers {
+			c()
+		}
+	}, nil
+}
+
+// startRun starts the root span of the run, the phases and uploads are its children
+func startRun(name string) trace.Span {
+	var span trace.Span
+	runCtx, span = tracer.Start(context.Background(), name)
+	traceCtx = runCtx
+	return span
+}
+
+// startSpan starts a span under the current phase
+func startSpan(name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(traceCtx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// spanAttrs converts the slog style key value pairs of a phase to span attributes
+func spanAttrs(args []any) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0)
+	for i := 0; i+1 < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		switch v := args[i+1].(type) {
+		case int:
+			attrs = append(attrs, attribute.Int(key, v))
+		case int64:
+			attrs = append(attrs, attribute.Int64(key, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(key, v))
+		default:
+			attrs = append(attrs, attribute.String(key, redact(fmt.Sprint(v))))
+		}
+	}
+	return attrs
+}
+
+// newTracedClient returns a resty client with a span for every request and the trace context in the request headers
+func newTracedClient() *resty.Client {
+	transport := otelhttp.NewTransport(http.DefaultTransport,
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}))
+
+	client := resty.New().SetTransport(transport)
+	client.OnBeforeRequest(func(c *resty.Client, r *resty.Request) error {
+		if r.Context() == context.Background() {
+			r.SetContext(traceCtx)
+		}
+		return nil
+	})
+	return client
+}
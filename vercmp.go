@@ -0,0 +1,397 @@
+// Package main - Ecosystem aware version comparison for vulnerability matching
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/mod/semver"
+)
+
+// compareVersions compares two versions using the ordering rules of the OSV ecosystem.  Returns -1, 0 or 1.
+func compareVersions(ecosystem string, a string, b string) int {
+	eco, _, _ := strings.Cut(ecosystem, ":")
+
+	switch eco {
+	case "Go", "npm":
+		return compareSemver(a, b)
+	case "PyPI":
+		return comparePEP440(a, b)
+	case "Maven":
+		return compareMaven(a, b)
+	case "Debian", "Ubuntu":
+		return compareDebian(a, b)
+	}
+	return compareNatural(a, b)
+}
+
+// compareSemver compares semantic versions with or without the leading v
+func compareSemver(a string, b string) int {
+	va := "v" + strings.TrimPrefix(a, "v")
+	vb := "v" + strings.TrimPrefix(b, "v")
+
+	if !semver.IsValid(va) || !semver.IsValid(vb) {
+		return compareNatural(a, b)
+	}
+	return semver.Compare(va, vb)
+}
+
+// compareInts returns the ordering of two integers
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareNumeric compares two digit strings of any length without overflowing
+func compareNumeric(a string, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+
+	if len(a) != len(b) {
+		return compareInts(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// splitNatural breaks a version into alternating runs of digits and non digits
+func splitNatural(v string) []string {
+	parts := make([]string, 0)
+
+	start := 0
+	for i := 1; i <= len(v); i++ {
+		if i == len(v) || unicode.IsDigit(rune(v[i])) != unicode.IsDigit(rune(v[i-1])) {
+			parts = append(parts, v[start:i])
+			start = i
+		}
+	}
+	return parts
+}
+
+// compareNatural is the fallback ordering comparing digit runs numerically and everything else lexically
+func compareNatural(a string, b string) int {
+	pa := splitNatural(a)
+	pb := splitNatural(b)
+
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		var c int
+		if unicode.IsDigit(rune(pa[i][0])) && unicode.IsDigit(rune(pb[i][0])) {
+			c = compareNumeric(pa[i], pb[i])
+		} else {
+			c = strings.Compare(pa[i], pb[i])
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(pa), len(pb))
+}
+
+// pep440Pattern parses a PEP 440 version into epoch, release, pre, post, dev and local segments
+var pep440Pattern = regexp.MustCompile(`^v?(?:(\d+)!)?(\d+(?:\.\d+)*)` +
+	`(?:[-_.]?(a|b|c|rc|alpha|beta|pre|preview)[-_.]?(\d+)?)?` +
+	`(?:-(\d+)|[-_.]?(post|rev|r)[-_.]?(\d+)?)?` +
+	`(?:[-_.]?(dev)[-_.]?(\d+)?)?` +
+	`(?:\+([a-z0-9]+(?:[-_.][a-z0-9]+)*))?$`)
+
+// pep440Version holds the comparable parts of a PEP 440 version.  Missing pre, post and dev segments use sentinels so the plain ordering works.
+type pep440Version struct {
+	epoch   int
+	release []string
+	pre     []int // phase (0=a, 1=b, 2=rc) and number
+	post    int
+	dev     int
+	local   string
+}
+
+// parsePEP440 returns the parsed version or false if it does not follow PEP 440
+func parsePEP440(v string) (pep440Version, bool) {
+	m := pep440Pattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(v)))
+	if m == nil {
+		return pep440Version{}, false
+	}
+
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	pv := pep440Version{epoch: atoi(m[1]), local: m[10]}
+
+	// Trailing zeros do not count, 1.0 == 1.0.0
+	pv.release = strings.Split(m[2], ".")
+	for len(pv.release) > 1 && strings.Trim(pv.release[len(pv.release)-1], "0") == "" {
+		pv.release = pv.release[:len(pv.release)-1]
+	}
+
+	switch m[3] {
+	case "":
+		pv.pre = []int{3, 0}
+		if len(m[8]) > 0 && len(m[5]) == 0 && len(m[6]) == 0 {
+			// A dev release of the final version sorts before its pre releases
+			pv.pre = []int{-1, 0}
+		}
+	case "a", "alpha":
+		pv.pre = []int{0, atoi(m[4])}
+	case "b", "beta":
+		pv.pre = []int{1, atoi(m[4])}
+	default:
+		pv.pre = []int{2, atoi(m[4])}
+	}
+
+	pv.post = -1
+	if len(m[5]) > 0 {
+		pv.post = atoi(m[5])
+	} else if len(m[6]) > 0 {
+		pv.post = atoi(m[7])
+	}
+
+	pv.dev = int(^uint(0) >> 1)
+	if len(m[8]) > 0 {
+		pv.dev = atoi(m[9])
+	}
+	return pv, true
+}
+
+// comparePEP440 compares python package versions
+func comparePEP440(a string, b string) int {
+	va, okA := parsePEP440(a)
+	vb, okB := parsePEP440(b)
+
+	if !okA || !okB {
+		return compareNatural(a, b)
+	}
+
+	if c := compareInts(va.epoch, vb.epoch); c != 0 {
+		return c
+	}
+
+	for i := 0; i < len(va.release) || i < len(vb.release); i++ {
+		ra, rb := "0", "0"
+		if i < len(va.release) {
+			ra = va.release[i]
+		}
+		if i < len(vb.release) {
+			rb = vb.release[i]
+		}
+
+		if c := compareNumeric(ra, rb); c != 0 {
+			return c
+		}
+	}
+
+	for i := range va.pre {
+		if c := compareInts(va.pre[i], vb.pre[i]); c != 0 {
+			return c
+		}
+	}
+
+	if c := compareInts(va.post, vb.post); c != 0 {
+		return c
+	}
+
+	if c := compareInts(va.dev, vb.dev); c != 0 {
+		return c
+	}
+	return compareNatural(va.local, vb.local)
+}
+
+// mavenQualifiers is the ordering of the well known Maven qualifiers, unknown qualifiers sort after them
+var mavenQualifiers = map[string]int{
+	"alpha":     1,
+	"beta":      2,
+	"milestone": 3,
+	"rc":        4,
+	"cr":        4,
+	"snapshot":  5,
+	"":          6,
+	"ga":        6,
+	"final":     6,
+	"release":   6,
+	"sp":        7,
+}
+
+// mavenItem is a single token of a Maven version
+type mavenItem struct {
+	numeric bool
+	value   string
+}
+
+// tokenizeMaven splits a Maven version on dots, dashes and digit/letter transitions and drops trailing null items
+func tokenizeMaven(v string) []mavenItem {
+	items := make([]mavenItem, 0)
+
+	for _, field := range strings.FieldsFunc(strings.ToLower(v), func(r rune) bool { return r == '.' || r == '-' || r == '_' }) {
+		parts := splitNatural(field)
+
+		for i, part := range parts {
+			if unicode.IsDigit(rune(part[0])) {
+				items = append(items, mavenItem{numeric: true, value: part})
+				continue
+			}
+
+			// a1, b2 and m3 are shorthands when directly followed by a number
+			if i+1 < len(parts) {
+				switch part {
+				case "a":
+					part = "alpha"
+				case "b":
+					part = "beta"
+				case "m":
+					part = "milestone"
+				}
+			}
+			items = append(items, mavenItem{value: part})
+		}
+	}
+
+	for len(items) > 0 {
+		last := items[len(items)-1]
+		if (last.numeric && strings.Trim(last.value, "0") == "") || (!last.numeric && mavenQualifiers[last.value] == 6) {
+			items = items[:len(items)-1]
+			continue
+		}
+		break
+	}
+	return items
+}
+
+// compareMavenQualifier orders qualifiers by the well known list and then lexically
+func compareMavenQualifier(a string, b string) int {
+	ra, knownA := mavenQualifiers[a]
+	rb, knownB := mavenQualifiers[b]
+
+	if !knownA {
+		ra = 8
+	}
+	if !knownB {
+		rb = 8
+	}
+
+	if c := compareInts(ra, rb); c != 0 || knownA {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// compareMaven compares Maven artifact versions following the ComparableVersion rules
+func compareMaven(a string, b string) int {
+	ia := tokenizeMaven(a)
+	ib := tokenizeMaven(b)
+
+	for i := 0; i < len(ia) || i < len(ib); i++ {
+		switch {
+		case i >= len(ia):
+			if ib[i].numeric {
+				if c := compareNumeric("0", ib[i].value); c != 0 {
+					return c
+				}
+				continue
+			}
+			return compareMavenQualifier("", ib[i].value)
+		case i >= len(ib):
+			if ia[i].numeric {
+				if c := compareNumeric(ia[i].value, "0"); c != 0 {
+					return c
+				}
+				continue
+			}
+			return compareMavenQualifier(ia[i].value, "")
+		case ia[i].numeric && ib[i].numeric:
+			if c := compareNumeric(ia[i].value, ib[i].value); c != 0 {
+				return c
+			}
+		case ia[i].numeric:
+			return 1
+		case ib[i].numeric:
+			return -1
+		default:
+			if c := compareMavenQualifier(ia[i].value, ib[i].value); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// splitDebian breaks a Debian version into epoch, upstream version and revision
+func splitDebian(v string) (int, string, string) {
+	epoch := 0
+	if e, rest, found := strings.Cut(v, ":"); found {
+		epoch, _ = strconv.Atoi(e)
+		v = rest
+	}
+
+	revision := ""
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		revision = v[i+1:]
+		v = v[:i]
+	}
+	return epoch, v, revision
+}
+
+// debianOrder is the dpkg sort weight of a non digit character, ~ sorts before everything including the end of the string
+func debianOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case unicode.IsDigit(rune(c)):
+		return 0
+	case unicode.IsLetter(rune(c)):
+		return int(c)
+	}
+	return int(c) + 256
+}
+
+// compareDebianPart is the dpkg verrevcmp algorithm for the upstream version or revision
+func compareDebianPart(a string, b string) int {
+	for len(a) > 0 || len(b) > 0 {
+		// Non digit prefix compared character by character
+		for (len(a) > 0 && !unicode.IsDigit(rune(a[0]))) || (len(b) > 0 && !unicode.IsDigit(rune(b[0]))) {
+			oa, ob := 0, 0
+			if len(a) > 0 {
+				oa = debianOrder(a[0])
+			}
+			if len(b) > 0 {
+				ob = debianOrder(b[0])
+			}
+
+			if oa != ob {
+				return compareInts(oa, ob)
+			}
+			a, b = a[1:], b[1:]
+		}
+
+		// Digit runs compared numerically
+		da := len(a) - len(strings.TrimLeftFunc(a, unicode.IsDigit))
+		db := len(b) - len(strings.TrimLeftFunc(b, unicode.IsDigit))
+
+		if c := compareNumeric(a[:da], b[:db]); c != 0 {
+			return c
+		}
+		a, b = a[da:], b[db:]
+	}
+	return 0
+}
+
+// compareDebian compares Debian package versions the way dpkg does
+func compareDebian(a string, b string) int {
+	ea, ua, ra := splitDebian(a)
+	eb, ub, rb := splitDebian(b)
+
+	if c := compareInts(ea, eb); c != 0 {
+		return c
+	}
+
+	if c := compareDebianPart(ua, ub); c != 0 {
+		return c
+	}
+	return compareDebianPart(ra, rb)
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		ecosystem string
+		a         string
+		b         string
+		want      int
+	}{
+		// Semantic versions with or without the v prefix
+		{"Go", "1.2.3", "v1.10.0", -1},
+		{"Go", "v1.0.0-rc.1", "v1.0.0", -1},
+		{"npm", "1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"npm", "2.0.0", "2.0.0", 0},
+
+		// PEP 440: dev < pre < final < post, trailing zeros do not count and the epoch wins
+		{"PyPI", "1.0.dev1", "1.0a1", -1},
+		{"PyPI", "1.0a1", "1.0b2", -1},
+		{"PyPI", "1.0b2", "1.0rc1", -1},
+		{"PyPI", "1.0rc1", "1.0", -1},
+		{"PyPI", "1.0", "1.0.post1", -1},
+		{"PyPI", "1.0", "1.0.0", 0},
+		{"PyPI", "1!0.1", "2.0", 1},
+
+		// Maven ComparableVersion: qualifiers before the release, sp after it, ga and trailing zeros are the release
+		{"Maven", "1.0-alpha1", "1.0-beta1", -1},
+		{"Maven", "1.0-rc1", "1.0-SNAPSHOT", -1},
+		{"Maven", "1.0-SNAPSHOT", "1.0", -1},
+		{"Maven", "1.0", "1.0.0-ga", 0},
+		{"Maven", "1.0", "1.0-sp1", -1},
+		{"Maven", "1.0.1", "1.0-sp1", 1},
+		{"Maven", "1.10", "1.9", 1},
+
+		// dpkg: ~ sorts before the end, the epoch wins, then the upstream version and the revision
+		{"Debian", "1.0~rc1", "1.0", -1},
+		{"Debian:12", "1:0.9", "2.0", 1},
+		{"Ubuntu", "1.0-1", "1.0-2", -1},
+		{"Debian", "1.0a", "1.0", 1},
+		{"Debian", "1.0+b1", "1.0a", 1},
+
+		// Other ecosystems compare digit runs numerically
+		{"crates.io", "1.10", "1.9", 1},
+		{"RubyGems", "2.0.0", "2.0.0", 0},
+		{"Hex", "0.9.1", "0.10.0", -1},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.ecosystem, tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q, %q) = %d, want %d", tt.ecosystem, tt.a, tt.b, got, tt.want)
+		}
+
+		if got := compareVersions(tt.ecosystem, tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q, %q) = %d, want %d", tt.ecosystem, tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
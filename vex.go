@@ -0,0 +1,471 @@
+// Package main - OpenVEX and CycloneDX VEX ingestion and generation
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	packageurl "github.com/anchore/packageurl-go"
+	"github.com/google/uuid"
+	"github.com/mkideal/cli"
+)
+
+// vexFiles is the default search list for VEX documents next to component.toml
+var vexFiles = []string{"vex.json", "openvex.json", "*.vex.json", "*.openvex.json", ".vex/*.json", "vex/*.json"}
+
+// openVEXContext is the OpenVEX spec version used for generated documents
+const openVEXContext = "https://openvex.dev/ns/v0.2.0"
+
+// vexStatuses are the valid OpenVEX statuses
+var vexStatuses = map[string]bool{"not_affected": true, "affected": true, "fixed": true, "under_investigation": true}
+
+// cdxVEXStates maps the CycloneDX analysis state to the OpenVEX status
+var cdxVEXStates = map[string]string{
+	"not_affected":           "not_affected",
+	"false_positive":         "not_affected",
+	"exploitable":            "affected",
+	"resolved":               "fixed",
+	"resolved_with_pedigree": "fixed",
+	"in_triage":              "under_investigation",
+}
+
+// vexStatement is a VEX statement normalized from either OpenVEX or CycloneDX
+type vexStatement struct {
+	Vulnerability string   `json:"vulnerability"`
+	Products      []string `json:"products"`
+	Status        string   `json:"status"`
+	Justification string   `json:"justification,omitempty"`
+	Impact        string   `json:"impact_statement,omitempty"`
+	Action        string   `json:"action_statement,omitempty"`
+}
+
+// vexDocument is the body posted to the vex endpoint, it follows the same layout as model.SBOM
+type vexDocument struct {
+	Key     string          `json:"_key,omitempty"`
+	ObjType string          `json:"objtype,omitempty"`
+	Format  string          `json:"format,omitempty"`
+	Content json.RawMessage `json:"content"`
+}
+
+// newVEXDocument is the contructor that sets the appropriate default values
+func newVEXDocument() *vexDocument {
+	return &vexDocument{ObjType: "VEX"}
+}
+
+// findVEXFiles returns the VEX documents matching the glob list, defaulting to vexFiles
+func findVEXFiles(patterns []string) []string {
+	if len(patterns) == 0 {
+		patterns = vexFiles
+	}
+
+	files := make([]string, 0)
+	seen := make(map[string]bool, 0)
+
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(strings.TrimSpace(pattern))
+
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+	return files
+}
+
+// parseVEX reads an OpenVEX or CycloneDX VEX document into normalized statements.  CycloneDX bom-refs are resolved to purls with the lookup.
+func parseVEX(data []byte, bomRefs map[string]string) (string, []vexStatement, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", nil, err
+	}
+
+	if context, found := doc["@context"]; found && strings.Contains(string(context), "openvex") {
+		statements, err := parseOpenVEX(doc)
+		return "OpenVEX", statements, err
+	}
+
+	if format, found := doc["bomFormat"]; found && string(format) == `"CycloneDX"` {
+		statements, err := parseCycloneDXVEX(doc, bomRefs)
+		return "CycloneDX", statements, err
+	}
+	return "", nil, fmt.Errorf("not an OpenVEX or CycloneDX VEX document")
+}
+
+// parseOpenVEX handles both the v0.0.1 string form and the v0.2.0 object form of vulnerabilities and products
+func parseOpenVEX(doc map[string]json.RawMessage) ([]vexStatement, error) {
+	var raw []struct {
+		Vulnerability   json.RawMessage   `json:"vulnerability"`
+		Products        []json.RawMessage `json:"products"`
+		Status          string            `json:"status"`
+		Justification   string            `json:"justification"`
+		ImpactStatement string            `json:"impact_statement"`
+		ActionStatement string            `json:"action_statement"`
+	}
+
+	if err := json.Unmarshal(doc["statements"], &raw); err != nil {
+		return nil, fmt.Errorf("invalid OpenVEX statements: %v", err)
+	}
+
+	// idOf accepts "id" or {"@id": "id", "name": "id"}
+	idOf := func(v json.RawMessage) string {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			return s
+		}
+
+		var obj struct {
+			ID   string `json:"@id"`
+			Name string `json:"name"`
+		}
+		json.Unmarshal(v, &obj)
+
+		if len(obj.Name) > 0 {
+			return obj.Name
+		}
+		return obj.ID
+	}
+
+	statements := make([]vexStatement, 0, len(raw))
+	for _, r := range raw {
+		stmt := vexStatement{
+			Vulnerability: idOf(r.Vulnerability),
+			Status:        r.Status,
+			Justification: r.Justification,
+			Impact:        r.ImpactStatement,
+			Action:        r.ActionStatement,
+		}
+
+		for _, p := range r.Products {
+			stmt.Products = append(stmt.Products, idOf(p))
+		}
+		statements = append(statements, stmt)
+	}
+	return statements, nil
+}
+
+// parseCycloneDXVEX reads the vulnerabilities analysis of a CycloneDX VEX
+func parseCycloneDXVEX(doc map[string]json.RawMessage, bomRefs map[string]string) ([]vexStatement, error) {
+	var vulns []struct {
+		ID       string `json:"id"`
+		Analysis struct {
+			State         string   `json:"state"`
+			Justification string   `json:"justification"`
+			Response      []string `json:"response"`
+			Detail        string   `json:"detail"`
+		} `json:"analysis"`
+		Affects []struct {
+			Ref string `json:"ref"`
+		} `json:"affects"`
+	}
+
+	if raw, found := doc["vulnerabilities"]; found {
+		if err := json.Unmarshal(raw, &vulns); err != nil {
+			return nil, fmt.Errorf("invalid CycloneDX vulnerabilities: %v", err)
+		}
+	}
+
+	statements := make([]vexStatement, 0, len(vulns))
+	for _, v := range vulns {
+		stmt := vexStatement{
+			Vulnerability: v.ID,
+			Status:        cdxVEXStates[v.Analysis.State],
+			Justification: v.Analysis.Justification,
+			Impact:        v.Analysis.Detail,
+			Action:        strings.Join(v.Analysis.Response, ","),
+		}
+
+		for _, a := range v.Affects {
+			ref := a.Ref
+
+			// Refs may point at a bom-ref inside the SBOM, with or without the urn:cdx prefix
+			if _, bomRef, found := strings.Cut(ref, "#"); found && strings.HasPrefix(ref, "urn:cdx:") {
+				ref = bomRef
+			}
+
+			if purl, found := bomRefs[ref]; found {
+				ref = purl
+			}
+			stmt.Products = append(stmt.Products, ref)
+		}
+		statements = append(statements, stmt)
+	}
+	return statements, nil
+}
+
+// purlMatches checks whether the VEX product purl refers to the SBOM purl.  Qualifiers are ignored and a product without a version matches every version.
+func purlMatches(product string, sbomPurl string) bool {
+	p, err := packageurl.FromString(product)
+	if err != nil {
+		return false
+	}
+
+	s, err := packageurl.FromString(sbomPurl)
+	if err != nil {
+		return false
+	}
+
+	if p.Type != s.Type || p.Namespace != s.Namespace || p.Name != s.Name {
+		return false
+	}
+	return len(p.Version) == 0 || p.Version == s.Version
+}
+
+// validateVEX checks the statements for valid status values and that every product is a package in the SBOM.  Returns the list of problems found.
+func validateVEX(statements []vexStatement, purls []string) []string {
+	problems := make([]string, 0)
+
+	for i, stmt := range statements {
+		name := fmt.Sprintf("statement %d (%s)", i+1, stmt.Vulnerability)
+
+		if len(stmt.Vulnerability) == 0 {
+			problems = append(problems, name+": missing vulnerability")
+		}
+
+		if !vexStatuses[stmt.Status] {
+			problems = append(problems, fmt.Sprintf("%s: invalid status %q", name, stmt.Status))
+		}
+
+		if stmt.Status == "not_affected" && len(stmt.Justification) == 0 && len(stmt.Impact) == 0 {
+			problems = append(problems, name+": not_affected requires a justification or impact statement")
+		}
+
+		if len(stmt.Products) == 0 {
+			problems = append(problems, name+": no products")
+		}
+
+		if purls == nil {
+			continue
+		}
+
+		for _, product := range stmt.Products {
+			found := false
+			for _, purl := range purls {
+				if purlMatches(product, purl) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				problems = append(problems, fmt.Sprintf("%s: product %s is not in the SBOM", name, product))
+			}
+		}
+	}
+	return problems
+}
+
+// generateVEX creates an OpenVEX skeleton with one under_investigation statement per vulnerability found by the scan
+func generateVEX(findings []vulnFinding, author string) map[string]interface{} {
+	products := make(map[string][]string, 0)
+	ids := make([]string, 0)
+
+	for _, f := range findings {
+		if _, found := products[f.ID]; !found {
+			ids = append(ids, f.ID)
+		}
+		products[f.ID] = append(products[f.ID], f.Purl)
+	}
+	sort.Strings(ids)
+
+	statements := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		prods := make([]interface{}, 0)
+		for _, purl := range products[id] {
+			prods = append(prods, map[string]string{"@id": purl})
+		}
+
+		statements = append(statements, map[string]interface{}{
+			"vulnerability": map[string]string{"name": id},
+			"products":      prods,
+			"status":        "under_investigation",
+		})
+	}
+
+	// OpenVEX requires an author
+	if len(author) == 0 {
+		author = "scec-cli"
+	}
+
+	// Every generated document is a new document, even for the same findings of another component
+	return map[string]interface{}{
+		"@context":   openVEXContext,
+		"@id":        uuid.New().URN(),
+		"author":     author,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		"version":    1,
+		"statements": statements,
+	}
+}
+
+// loadVEXFiles reads and validates the VEX documents against the SBOMs.  Documents with problems are logged as warnings and skipped, only the valid ones are returned for upload.
+func loadVEXFiles(patterns []string, sbomData [][]byte) []*vexDocument {
+	var purls []string
+	bomRefs := make(map[string]string, 0)
+
+	for _, data := range sbomData {
+		p, refs, err := getSBOMPurls(data)
+		if err != nil {
+			continue
+		}
+
+		purls = append(purls, p...)
+		for k, v := range refs {
+			bomRefs[k] = v
+		}
+	}
+
+	docs := make([]*vexDocument, 0)
+	for _, filename := range findVEXFiles(patterns) {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			slog.Warn("could not read the VEX", "file", filename, "error", err)
+			continue
+		}
+
+		format, statements, err := parseVEX(data, bomRefs)
+		if err != nil {
+			slog.Warn("skipping the VEX", "file", filename, "error", err)
+			continue
+		}
+
+		if problems := validateVEX(statements, purls); len(problems) > 0 {
+			for _, problem := range problems {
+				slog.Warn("invalid VEX", "file", filename, "problem", problem)
+			}
+			slog.Warn("skipping the VEX", "file", filename, "problems", len(problems))
+			continue
+		}
+
+		doc := newVEXDocument()
+		doc.Format = format
+		doc.Content = json.RawMessage(data)
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+type vexGenerateT struct {
+	cli.Helper
+	Findings string `cli:"findings" usage:"Findings json written by vuln scan"`
+	SBOM     string `cli:"sbom" usage:"CycloneDX or SPDX Json Filename to scan when no findings are given"`
+	DB       string `cli:"db" usage:"Directory or zip files of the OSV database"`
+	Author   string `cli:"author" usage:"Author of the VEX document" dft:"$USER"`
+	Output   string `cli:"o,output" usage:"Output filename, defaults to stdout"`
+}
+
+// Validate implements cli.Validator interface
+func (argv *vexGenerateT) Validate(ctx *cli.Context) error {
+	if len(argv.Findings) == 0 && (len(argv.SBOM) == 0 || len(argv.DB) == 0) {
+		return fmt.Errorf("either --findings or both --sbom and --db are required")
+	}
+	return nil
+}
+
+type vexValidateT struct {
+	cli.Helper
+	VEX  string `cli:"*vex" usage:"OpenVEX or CycloneDX VEX Json Filename (required)"`
+	SBOM string `cli:"sbom" usage:"CycloneDX or SPDX Json Filename the products must be part of"`
+}
+
+var vexCmd = &cli.Command{
+	Name: "vex",
+	Desc: "VEX utilities",
+	Fn: func(ctx *cli.Context) error {
+		ctx.WriteUsage()
+		return nil
+	},
+}
+
+var vexGenerateCmd = &cli.Command{
+	Name: "generate",
+	Desc: "Generate an OpenVEX skeleton from the vulnerability scan findings",
+	Argv: func() interface{} { return new(vexGenerateT) },
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*vexGenerateT)
+
+		findings := make([]vulnFinding, 0)
+		if len(argv.Findings) > 0 {
+			data, err := os.ReadFile(argv.Findings)
+			if err != nil {
+				return err
+			}
+
+			if err := json.Unmarshal(data, &findings); err != nil {
+				return fmt.Errorf("could not parse findings %s: %v", argv.Findings, err)
+			}
+		} else {
+			data, err := os.ReadFile(argv.SBOM)
+			if err != nil {
+				return err
+			}
+
+			if findings, err = scanSBOM(data, argv.DB); err != nil {
+				return err
+			}
+		}
+
+		out, err := json.MarshalIndent(generateVEX(findings, argv.Author), "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if len(argv.Output) == 0 {
+			fmt.Println(string(out))
+			return nil
+		}
+		return os.WriteFile(argv.Output, append(out, '\n'), 0o644)
+	},
+}
+
+var vexValidateCmd = &cli.Command{
+	Name: "validate",
+	Desc: "Validate a VEX document and check its products against the SBOM",
+	Argv: func() interface{} { return new(vexValidateT) },
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*vexValidateT)
+
+		var purls []string
+		bomRefs := make(map[string]string, 0)
+
+		if len(argv.SBOM) > 0 {
+			data, err := os.ReadFile(argv.SBOM)
+			if err != nil {
+				return err
+			}
+
+			if purls, bomRefs, err = getSBOMPurls(data); err != nil {
+				return fmt.Errorf("could not parse %s: %v", argv.SBOM, err)
+			}
+		}
+
+		data, err := os.ReadFile(argv.VEX)
+		if err != nil {
+			return err
+		}
+
+		format, statements, err := parseVEX(data, bomRefs)
+		if err != nil {
+			return fmt.Errorf("could not parse %s: %v", argv.VEX, err)
+		}
+
+		problems := validateVEX(statements, purls)
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+
+		if len(problems) > 0 {
+			return fmt.Errorf("%s VEX %s has %d problems", format, argv.VEX, len(problems))
+		}
+
+		fmt.Printf("%s VEX %s is valid, %d statements\n", format, argv.VEX, len(statements))
+		return nil
+	},
+}
@@ -0,0 +1,580 @@
+// Package main - Offline vulnerability matching of SBOM components against an OSV database
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	packageurl "github.com/anchore/packageurl-go"
+	"github.com/mkideal/cli"
+)
+
+// sbomPackage is a component from the SBOM mapped to its OSV ecosystem and package name
+type sbomPackage struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Purl      string `json:"purl"`
+	Ecosystem string `json:"ecosystem"`
+	OSVName   string `json:"osvname"`
+	Release   string `json:"-"` // distro release for OS packages, ie 11 for debian-11
+}
+
+// osvEvent is a single introduced, fixed, last_affected or limit event of a range
+type osvEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// osvRange defines the affected version ranges for a package
+type osvRange struct {
+	Type   string     `json:"type"`
+	Events []osvEvent `json:"events"`
+}
+
+// osvAffected defines a package affected by the vulnerability
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+		Purl      string `json:"purl,omitempty"`
+	} `json:"package"`
+	Ranges           []osvRange             `json:"ranges,omitempty"`
+	Versions         []string               `json:"versions,omitempty"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific,omitempty"`
+}
+
+// osvEntry is a vulnerability in the OSV schema
+type osvEntry struct {
+	ID       string   `json:"id"`
+	Summary  string   `json:"summary,omitempty"`
+	Details  string   `json:"details,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Severity []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity,omitempty"`
+	Affected         []osvAffected          `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific,omitempty"`
+}
+
+// vulnFinding is a vulnerability matched to a SBOM package
+type vulnFinding struct {
+	ID           string   `json:"id"`
+	Aliases      []string `json:"aliases,omitempty"`
+	Summary      string   `json:"summary,omitempty"`
+	Severity     string   `json:"severity"`
+	CVSS         string   `json:"cvss,omitempty"`
+	Package      string   `json:"package"`
+	Version      string   `json:"version"`
+	Purl         string   `json:"purl"`
+	Ecosystem    string   `json:"ecosystem"`
+	FixedVersion string   `json:"fixed_version,omitempty"`
+}
+
+// purlEcosystems maps the purl type to the OSV ecosystem name
+var purlEcosystems = map[string]string{
+	"golang": "Go",
+	"npm":    "npm",
+	"pypi":   "PyPI",
+	"maven":  "Maven",
+	"deb":    "Debian",
+}
+
+// pypiNamePattern matches the separators that PEP 503 treats as equal
+var pypiNamePattern = regexp.MustCompile(`[-_.]+`)
+
+// osvKey is the index key for an ecosystem and package name
+func osvKey(ecosystem string, name string) string {
+	eco, _, _ := strings.Cut(ecosystem, ":")
+
+	if eco == "PyPI" {
+		name = pypiNamePattern.ReplaceAllString(strings.ToLower(name), "-")
+	}
+	return eco + "|" + name
+}
+
+// purlToPackage maps a purl to the OSV ecosystem and package name.  Returns false for ecosystems without OSV support.
+func purlToPackage(purl string) (sbomPackage, bool) {
+	p, err := packageurl.FromString(purl)
+	if err != nil {
+		return sbomPackage{}, false
+	}
+
+	ecosystem, found := purlEcosystems[p.Type]
+	if !found || len(p.Version) == 0 {
+		return sbomPackage{}, false
+	}
+
+	pkg := sbomPackage{Name: p.Name, Version: p.Version, Purl: purl, Ecosystem: ecosystem, OSVName: p.Name}
+	qualifiers := p.Qualifiers.Map()
+
+	switch p.Type {
+	case "golang", "npm":
+		if len(p.Namespace) > 0 {
+			pkg.OSVName = p.Namespace + "/" + p.Name
+		}
+	case "maven":
+		pkg.OSVName = p.Namespace + ":" + p.Name
+	case "deb":
+		// OSV tracks Debian vulnerabilities by source package
+		if upstream, found := qualifiers["upstream"]; found {
+			pkg.OSVName, _, _ = strings.Cut(upstream, "@")
+		}
+
+		if distro, found := qualifiers["distro"]; found {
+			_, pkg.Release, _ = strings.Cut(distro, "-")
+		}
+	}
+	return pkg, true
+}
+
+// getSBOMPurls returns every purl in a CycloneDX or SPDX json SBOM and a lookup of the CycloneDX bom-refs to their purl
+func getSBOMPurls(data []byte) ([]string, map[string]string, error) {
+	var doc struct {
+		Components []json.RawMessage `json:"components"`
+		Packages   []struct {
+			ExternalRefs []struct {
+				ReferenceType    string `json:"referenceType"`
+				ReferenceLocator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+	}
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	purls := make([]string, 0)
+	bomRefs := make(map[string]string, 0)
+
+	// CycloneDX components can be nested
+	var walk func(components []json.RawMessage)
+	walk = func(components []json.RawMessage) {
+		for _, raw := range components {
+			var comp struct {
+				BomRef     string            `json:"bom-ref"`
+				Purl       string            `json:"purl"`
+				Components []json.RawMessage `json:"components"`
+			}
+
+			if err := json.Unmarshal(raw, &comp); err != nil {
+				continue
+			}
+
+			if len(comp.Purl) > 0 {
+				purls = append(purls, comp.Purl)
+
+				if len(comp.BomRef) > 0 {
+					bomRefs[comp.BomRef] = comp.Purl
+				}
+			}
+			walk(comp.Components)
+		}
+	}
+	walk(doc.Components)
+
+	for _, spdxPkg := range doc.Packages {
+		for _, ref := range spdxPkg.ExternalRefs {
+			if ref.ReferenceType == "purl" {
+				purls = append(purls, ref.ReferenceLocator)
+			}
+		}
+	}
+	return purls, bomRefs, nil
+}
+
+// getSBOMPackages returns the packages with a purl in an OSV supported ecosystem from a CycloneDX or SPDX json SBOM
+func getSBOMPackages(data []byte) ([]sbomPackage, error) {
+	purls, _, err := getSBOMPurls(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := make([]sbomPackage, 0)
+	seen := make(map[string]bool, 0)
+	for _, purl := range purls {
+		if seen[purl] {
+			continue
+		}
+		seen[purl] = true
+
+		if pkg, ok := purlToPackage(purl); ok {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs, nil
+}
+
+// loadOSVDatabase reads the OSV json files from a directory tree or zip dumps, keeping only the entries for the wanted packages
+func loadOSVDatabase(dbpath string, pkgs []sbomPackage) (map[string][]*osvEntry, error) {
+	wanted := make(map[string]bool, len(pkgs))
+	for _, pkg := range pkgs {
+		wanted[osvKey(pkg.Ecosystem, pkg.OSVName)] = true
+	}
+
+	db := make(map[string][]*osvEntry, 0)
+
+	addEntry := func(r io.Reader) {
+		var entry osvEntry
+		if err := json.NewDecoder(r).Decode(&entry); err != nil {
+			return
+		}
+
+		added := make(map[string]bool, 0)
+		for _, affected := range entry.Affected {
+			key := osvKey(affected.Package.Ecosystem, affected.Package.Name)
+
+			if wanted[key] && !added[key] {
+				db[key] = append(db[key], &entry)
+				added[key] = true
+			}
+		}
+	}
+
+	err := filepath.WalkDir(dbpath, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".json":
+			f, err := os.Open(filename)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			addEntry(f)
+		case ".zip":
+			archive, err := zip.OpenReader(filename)
+			if err != nil {
+				return err
+			}
+			defer archive.Close()
+
+			for _, zf := range archive.File {
+				if !strings.HasSuffix(strings.ToLower(zf.Name), ".json") {
+					continue
+				}
+
+				r, err := zf.Open()
+				if err != nil {
+					return err
+				}
+				addEntry(r)
+				r.Close()
+			}
+		}
+		return nil
+	})
+	return db, err
+}
+
+// isAffected evaluates the OSV ranges and versions for the package version and returns the first fixed version if any
+func isAffected(ecosystem string, affected osvAffected, version string) (bool, string) {
+	for _, v := range affected.Versions {
+		if v == version {
+			return true, ""
+		}
+	}
+
+	for _, r := range affected.Ranges {
+		if r.Type != "SEMVER" && r.Type != "ECOSYSTEM" {
+			continue
+		}
+
+		// Evaluate the events in version order, each event toggling whether the version is affected
+		events := append([]osvEvent{}, r.Events...)
+		sort.SliceStable(events, func(i, j int) bool {
+			return compareVersions(ecosystem, eventVersion(events[i]), eventVersion(events[j])) < 0
+		})
+
+		vulnerable := false
+		fixed := ""
+		for _, e := range events {
+			switch {
+			case len(e.Introduced) > 0:
+				// introduced "0" means every version
+				if e.Introduced == "0" || compareVersions(ecosystem, e.Introduced, version) <= 0 {
+					vulnerable = true
+				}
+			case len(e.Fixed) > 0:
+				if compareVersions(ecosystem, version, e.Fixed) >= 0 {
+					vulnerable = false
+				} else if vulnerable && len(fixed) == 0 {
+					fixed = e.Fixed
+				}
+			case len(e.LastAffected) > 0:
+				if compareVersions(ecosystem, version, e.LastAffected) > 0 {
+					vulnerable = false
+				}
+			case len(e.Limit) > 0:
+				if compareVersions(ecosystem, version, e.Limit) >= 0 {
+					vulnerable = false
+				}
+			}
+		}
+
+		if vulnerable {
+			return true, fixed
+		}
+	}
+	return false, ""
+}
+
+// eventVersion returns the version of whichever event field is set, with "0" sorting first
+func eventVersion(e osvEvent) string {
+	switch {
+	case len(e.Introduced) > 0:
+		if e.Introduced == "0" {
+			return ""
+		}
+		return e.Introduced
+	case len(e.Fixed) > 0:
+		return e.Fixed
+	case len(e.LastAffected) > 0:
+		return e.LastAffected
+	}
+	return e.Limit
+}
+
+// osvSeverity returns the severity level and CVSS vector of the entry
+func osvSeverity(entry *osvEntry) (string, string) {
+	cvss := ""
+	for _, s := range entry.Severity {
+		if strings.HasPrefix(s.Type, "CVSS") {
+			cvss = s.Score
+		}
+	}
+
+	if severity, ok := entry.DatabaseSpecific["severity"].(string); ok && len(severity) > 0 {
+		severity = strings.ToUpper(severity)
+		if severity == "MODERATE" {
+			severity = "MEDIUM"
+		}
+		return severity, cvss
+	}
+	return "UNKNOWN", cvss
+}
+
+// matchVulnerabilities checks every package version against the OSV entries for that package
+func matchVulnerabilities(pkgs []sbomPackage, db map[string][]*osvEntry) []vulnFinding {
+	findings := make([]vulnFinding, 0)
+
+	for _, pkg := range pkgs {
+		key := osvKey(pkg.Ecosystem, pkg.OSVName)
+
+		for _, entry := range db[key] {
+			for _, affected := range entry.Affected {
+				if osvKey(affected.Package.Ecosystem, affected.Package.Name) != key {
+					continue
+				}
+
+				// Distro specific entries only apply to the same release
+				_, release, _ := strings.Cut(affected.Package.Ecosystem, ":")
+				if len(release) > 0 && len(pkg.Release) > 0 && release != pkg.Release {
+					continue
+				}
+
+				vulnerable, fixed := isAffected(affected.Package.Ecosystem, affected, pkg.Version)
+				if !vulnerable {
+					continue
+				}
+
+				severity, cvss := osvSeverity(entry)
+				findings = append(findings, vulnFinding{
+					ID:           entry.ID,
+					Aliases:      entry.Aliases,
+					Summary:      entry.Summary,
+					Severity:     severity,
+					CVSS:         cvss,
+					Package:      pkg.OSVName,
+					Version:      pkg.Version,
+					Purl:         pkg.Purl,
+					Ecosystem:    pkg.Ecosystem,
+					FixedVersion: fixed,
+				})
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Purl != findings[j].Purl {
+			return findings[i].Purl < findings[j].Purl
+		}
+		return findings[i].ID < findings[j].ID
+	})
+	return findings
+}
+
+// scanSBOM matches the SBOM content against the OSV database directory
+func scanSBOM(sbomData []byte, dbpath string) ([]vulnFinding, error) {
+	pkgs, err := getSBOMPackages(sbomData)
+	if err != nil {
+		return nil, fmt.Errorf("could not read SBOM packages: %v", err)
+	}
+
+	db, err := loadOSVDatabase(dbpath, pkgs)
+	if err != nil {
+		return nil, fmt.Errorf("could not load OSV database %s: %v", dbpath, err)
+	}
+	return matchVulnerabilities(pkgs, db), nil
+}
+
+// vulnSummary counts the findings by severity for the component version attributes
+func vulnSummary(findings []vulnFinding) map[string]string {
+	counts := map[string]int{"CRITICAL": 0, "HIGH": 0, "MEDIUM": 0, "LOW": 0, "UNKNOWN": 0}
+	ids := make([]string, 0)
+
+	for _, f := range findings {
+		counts[f.Severity]++
+		ids = append(ids, f.ID)
+	}
+
+	summary := make(map[string]string, 0)
+	summary["VULN_TOTAL"] = fmt.Sprintf("%d", len(findings))
+	for severity, cnt := range counts {
+		summary["VULN_"+severity] = fmt.Sprintf("%d", cnt)
+	}
+	summary["VULN_IDS"] = strings.Join(ids, ",")
+	return summary
+}
+
+// sarifLevel maps the severity to a SARIF result level
+func sarifLevel(severity string) string {
+	switch severity {
+	case "CRITICAL", "HIGH":
+		return "error"
+	case "MEDIUM":
+		return "warning"
+	}
+	return "note"
+}
+
+// makeSARIF renders the findings as a SARIF 2.1.0 log with one rule per vulnerability
+func makeSARIF(findings []vulnFinding, sbomFile string) map[string]interface{} {
+	rules := make([]interface{}, 0)
+	results := make([]interface{}, 0)
+	seen := make(map[string]bool, 0)
+
+	for _, f := range findings {
+		if !seen[f.ID] {
+			seen[f.ID] = true
+			rules = append(rules, map[string]interface{}{
+				"id":               f.ID,
+				"shortDescription": map[string]string{"text": f.Summary},
+				"helpUri":          "https://osv.dev/vulnerability/" + f.ID,
+				"properties":       map[string]interface{}{"severity": f.Severity, "aliases": f.Aliases},
+			})
+		}
+
+		text := fmt.Sprintf("%s %s is affected by %s", f.Package, f.Version, f.ID)
+		if len(f.FixedVersion) > 0 {
+			text += ", fixed in " + f.FixedVersion
+		}
+
+		results = append(results, map[string]interface{}{
+			"ruleId":  f.ID,
+			"level":   sarifLevel(f.Severity),
+			"message": map[string]string{"text": text},
+			"locations": []interface{}{
+				map[string]interface{}{
+					"physicalLocation": map[string]interface{}{
+						"artifactLocation": map[string]string{"uri": sbomFile},
+					},
+					"logicalLocations": []interface{}{
+						map[string]string{"name": f.Purl, "kind": "package"},
+					},
+				},
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []interface{}{
+			map[string]interface{}{
+				"tool": map[string]interface{}{
+					"driver": map[string]interface{}{
+						"name":           "scec-cli",
+						"informationUri": "https://github.com/ortelius/scec-cli",
+						"rules":          rules,
+					},
+				},
+				"results": results,
+			},
+		},
+	}
+}
+
+type vulnScanT struct {
+	cli.Helper
+	SBOM   string `cli:"*sbom" usage:"CycloneDX or SPDX Json Filename (required)"`
+	DB     string `cli:"*db" usage:"Directory or zip files of the OSV database (required)"`
+	Format string `cli:"format" usage:"Output format: json or sarif" dft:"json"`
+	Output string `cli:"o,output" usage:"Output filename, defaults to stdout"`
+}
+
+// Validate implements cli.Validator interface
+func (argv *vulnScanT) Validate(ctx *cli.Context) error {
+	if argv.Format != "json" && argv.Format != "sarif" {
+		return fmt.Errorf("unknown format %s, use json or sarif", argv.Format)
+	}
+	return nil
+}
+
+var vulnCmd = &cli.Command{
+	Name: "vuln",
+	Desc: "Vulnerability utilities",
+	Fn: func(ctx *cli.Context) error {
+		ctx.WriteUsage()
+		return nil
+	},
+}
+
+var vulnScanCmd = &cli.Command{
+	Name: "scan",
+	Desc: "Match the SBOM packages against a local OSV database",
+	Argv: func() interface{} { return new(vulnScanT) },
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*vulnScanT)
+
+		data, err := os.ReadFile(argv.SBOM)
+		if err != nil {
+			return err
+		}
+
+		findings, err := scanSBOM(data, argv.DB)
+		if err != nil {
+			return err
+		}
+
+		var report interface{} = findings
+		if argv.Format == "sarif" {
+			report = makeSARIF(findings, argv.SBOM)
+		}
+
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Found %d vulnerabilities in %s\n", len(findings), argv.SBOM)
+		if len(argv.Output) == 0 {
+			fmt.Println(string(out))
+			return nil
+		}
+		return os.WriteFile(argv.Output, append(out, '\n'), 0o644)
+	},
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestIsAffected(t *testing.T) {
+	semverRange := func(events ...osvEvent) osvAffected {
+		return osvAffected{Ranges: []osvRange{{Type: "SEMVER", Events: events}}}
+	}
+
+	twoIntervals := semverRange(
+		osvEvent{Introduced: "2.0.0"}, osvEvent{Fixed: "2.3.0"},
+		osvEvent{Introduced: "1.0.0"}, osvEvent{Fixed: "1.2.0"},
+	)
+
+	tests := []struct {
+		name      string
+		ecosystem string
+		affected  osvAffected
+		version   string
+		want      bool
+		fixed     string
+	}{
+		{"introduced 0 covers every version", "npm", semverRange(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.2.0"}), "0.0.1", true, "1.2.0"},
+		{"before the fix", "npm", semverRange(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.2.0"}), "1.1.9", true, "1.2.0"},
+		{"the fixed version", "npm", semverRange(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.2.0"}), "1.2.0", false, ""},
+		{"before introduced", "npm", semverRange(osvEvent{Introduced: "1.0.0"}, osvEvent{Fixed: "2.0.0"}), "0.9.0", false, ""},
+		{"the introduced version", "npm", semverRange(osvEvent{Introduced: "1.0.0"}, osvEvent{Fixed: "2.0.0"}), "1.0.0", true, "2.0.0"},
+		{"last affected is affected", "Go", semverRange(osvEvent{Introduced: "1.0.0"}, osvEvent{LastAffected: "1.5.0"}), "1.5.0", true, ""},
+		{"after last affected", "Go", semverRange(osvEvent{Introduced: "1.0.0"}, osvEvent{LastAffected: "1.5.0"}), "1.5.1", false, ""},
+		{"below the limit", "Go", semverRange(osvEvent{Introduced: "0"}, osvEvent{Limit: "2.0.0"}), "1.9.9", true, ""},
+		{"at the limit", "Go", semverRange(osvEvent{Introduced: "0"}, osvEvent{Limit: "2.0.0"}), "2.0.0", false, ""},
+		{"unsorted events, first interval", "npm", twoIntervals, "1.1.0", true, "1.2.0"},
+		{"unsorted events, between intervals", "npm", twoIntervals, "1.5.0", false, ""},
+		{"unsorted events, second interval", "npm", twoIntervals, "2.1.0", true, "2.3.0"},
+		{"unsorted events, after both", "npm", twoIntervals, "2.3.0", false, ""},
+		{
+			"ecosystem ordering, a release candidate is before the fix", "PyPI",
+			osvAffected{Ranges: []osvRange{{Type: "ECOSYSTEM", Events: []osvEvent{{Introduced: "0"}, {Fixed: "1.0"}}}}},
+			"1.0rc1", true, "1.0",
+		},
+		{
+			"ecosystem ordering, dpkg tilde", "Debian:12",
+			osvAffected{Ranges: []osvRange{{Type: "ECOSYSTEM", Events: []osvEvent{{Introduced: "0"}, {Fixed: "2.4.1-1"}}}}},
+			"2.4.1~rc2-1", true, "2.4.1-1",
+		},
+		{"listed version", "npm", osvAffected{Versions: []string{"1.0.0", "1.0.1"}}, "1.0.1", true, ""},
+		{"unlisted version", "npm", osvAffected{Versions: []string{"1.0.0", "1.0.1"}}, "1.0.2", false, ""},
+		{
+			"git ranges are skipped", "npm",
+			osvAffected{Ranges: []osvRange{{Type: "GIT", Events: []osvEvent{{Introduced: "0"}}}}},
+			"1.0.0", false, "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fixed := isAffected(tt.ecosystem, tt.affected, tt.version)
+			if got != tt.want || fixed != tt.fixed {
+				t.Errorf("isAffected(%s) = %v, %q, want %v, %q", tt.version, got, fixed, tt.want, tt.fixed)
+			}
+		})
+	}
+}